@@ -4,15 +4,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
-	"strconv"
-	"strings"
 	"time"
 
+	"github.com/f5devcentral/bigip-tgw/history"
 	slog "github.com/go-eden/slf4go"
 	"github.com/xeipuuv/gojsonschema"
 )
 
-const as3SupportedVersion = 3.20
+// as3SupportedVersion is the oldest AS3 release bigip-tgw declarations work
+// with, the oldest schemaVersion they declare. The properties the gateway
+// renders without a feature check, such as pool member adminState, ratio and
+// FQDN members, the declaration id and remark or TLS_Client, are all part of it.
+var as3SupportedVersion = Version{Major: 3, Minor: 20}
 
 /*
 var baseAS3Config = `{
@@ -40,14 +43,23 @@ type AS3Manager struct {
 	enableTLS                 string
 	tls13CipherGroupReference string
 	ciphers                   string
-	// Active User Defined ConfigMap details
+	// as3ActiveConfig is the last declaration applied by the BIG-IP, it is
+	// restored when a newer one is rejected
 	as3ActiveConfig AS3Config
+	// generation numbers the declarations received on ReqChan
+	generation int
+	// rolledBack is the generation whose rejection was last rolled back
+	rolledBack int
+	// history saves the posted declarations when enabled
+	history         *history.Store
 	As3SchemaLatest string
 	Schema          string
 	// Override existing as3 declaration with this configmap
 	//OverriderCfgMapName string
 	// Path of schemas reside locally
-	//SchemaLocalPath string
+	schemaLocalPath string
+	// Compiled schema of the BIG-IP's AS3 version, set when as3Validation is on
+	as3Schema *gojsonschema.Schema
 	// POSTs configuration to BIG-IP using AS3
 	PostManager *PostManager
 	// To put list of tenants in BIG-IP REST call URL that are in AS3 declaration
@@ -62,6 +74,16 @@ type AS3Manager struct {
 	as3Version                string
 	as3Release                string
 	unprocessableEntityStatus bool
+	// schemaVersion is declared by the declarations, the configured
+	// SchemaVersion or the AS3 version of the BIG-IP
+	configuredSchemaVersion string
+	schemaVersion           Version
+	// driftCheckInterval is the number of seconds between drift checks of
+	// the tenant, drift is re-posted with driftCorrect
+	driftCheckInterval int
+	driftCorrect       bool
+	// deployerStopped is closed when ConfigDeployer returns
+	deployerStopped chan struct{}
 }
 
 // Struct to allow NewManager to receive all or only specific parameters.
@@ -80,9 +102,15 @@ type Params struct {
 	FilterTenants       bool
 	BIGIPUsername       string
 	BIGIPPassword       string
+	BIGIPLoginProvider  string
 	BIGIPURL            string
 	TrustedCerts        string
 	AS3PostDelay        int
+	AS3AsyncPost        bool
+	AS3TaskPollInterval int
+	AS3TaskDeadline     int
+	DriftCheckInterval  int
+	DriftCorrect        bool
 	//ConfigWriter        writer.Writer
 	EventChan chan interface{}
 	//Log the AS3 response body in Controller logs
@@ -108,6 +136,7 @@ func (ag *agentAS3) Init(params Params) error {
 	ag.AS3Manager = NewAS3Manager(&as3Params)
 
 	ag.ReqChan = make(chan AS3Config, 1)
+	ag.deployerStopped = make(chan struct{})
 	if ag.ReqChan != nil {
 		go ag.ConfigDeployer()
 	}
@@ -116,6 +145,9 @@ func (ag *agentAS3) Init(params Params) error {
 	if err != nil {
 		return err
 	}
+	if ag.as3Validation {
+		return ag.loadAS3Schema()
+	}
 	return nil
 }
 
@@ -135,6 +167,12 @@ func (ag *agentAS3) DeInit() error {
 	return nil
 }
 
+// Wait blocks until ConfigDeployer posted the declarations left on the
+// closed ReqChan and returned
+func (am *AS3Manager) Wait() {
+	<-am.deployerStopped
+}
+
 // Create and return a new app manager that meets the Manager interface
 func NewAS3Manager(params *Params) *AS3Manager {
 	as3Manager := AS3Manager{
@@ -144,6 +182,10 @@ func NewAS3Manager(params *Params) *AS3Manager {
 		tls13CipherGroupReference: params.TLS13CipherGroupReference,
 		ciphers:                   params.Ciphers,
 		Schema:                    params.Schema,
+		schemaLocalPath:           params.SchemaLocalPath,
+		configuredSchemaVersion:   params.SchemaVersion,
+		driftCheckInterval:        params.DriftCheckInterval,
+		driftCorrect:              params.DriftCorrect,
 		//FilterTenants:             params.FilterTenants,
 		RspChan:    params.RspChan,
 		userAgent:  params.UserAgent,
@@ -153,13 +195,17 @@ func NewAS3Manager(params *Params) *AS3Manager {
 		//l2l3Agent: L2L3Agent{eventChan: params.EventChan,
 		//	configWriter: params.ConfigWriter},
 		PostManager: NewPostManager(PostParams{
-			BIGIPUsername: params.BIGIPUsername,
-			BIGIPPassword: params.BIGIPPassword,
-			BIGIPURL:      params.BIGIPURL,
-			TrustedCerts:  params.TrustedCerts,
-			SSLInsecure:   params.SSLInsecure,
-			AS3PostDelay:  params.AS3PostDelay,
-			LogResponse:   params.LogResponse}),
+			BIGIPUsername:       params.BIGIPUsername,
+			BIGIPPassword:       params.BIGIPPassword,
+			BIGIPLoginProvider:  params.BIGIPLoginProvider,
+			BIGIPURL:            params.BIGIPURL,
+			TrustedCerts:        params.TrustedCerts,
+			SSLInsecure:         params.SSLInsecure,
+			AS3PostDelay:        params.AS3PostDelay,
+			AS3AsyncPost:        params.AS3AsyncPost,
+			AS3TaskPollInterval: params.AS3TaskPollInterval,
+			AS3TaskDeadline:     params.AS3TaskDeadline,
+			LogResponse:         params.LogResponse}),
 	}
 
 	//as3Manager.fetchAS3Schema()
@@ -186,8 +232,11 @@ func (am *AS3Manager) postAS3Declaration(as3Config AS3Config) (bool, string) {
 func (am *AS3Manager) postAS3Config(tempAS3Config AS3Config) (bool, string) {
 	unifiedDecl := tempAS3Config.JsonObj
 
-	if DeepEqualJSON(am.as3ActiveConfig.JsonObj, unifiedDecl) {
-		return !am.unprocessableEntityStatus, ""
+	// After a failure the state of the BIG-IP is not known, post anyway
+	if !am.unprocessableEntityStatus && DeepEqualJSON(am.as3ActiveConfig.JsonObj, unifiedDecl) {
+		log.Debugf("[AS3] Generation %d is identical to live generation %d, not posting it",
+			tempAS3Config.Generation, am.as3ActiveConfig.Generation)
+		return true, ""
 	}
 
 	if am.as3Validation == true {
@@ -196,18 +245,22 @@ func (am *AS3Manager) postAS3Config(tempAS3Config AS3Config) (bool, string) {
 		}
 	}
 
-	log.Debugf("[AS3] Posting AS3 Declaration")
-
-	//am.as3ActiveConfig.updateConfig(tempAS3Config)
+	log.Debugf("[AS3] Posting AS3 Declaration generation %d", tempAS3Config.Generation)
 
+	// Only touch the tenant of this declaration so that gateways and other
+	// AS3 users sharing the BIG-IP do not remove each other
 	var tenants []string = nil
-	tenants = append(tenants, "")
+	if tempAS3Config.Declaration.Tenant.Name != "" {
+		tenants = append(tenants, tempAS3Config.Declaration.Tenant.Name)
+	}
 
 	//if am.FilterTenants {
 	//	tenants = getTenants(unifiedDecl, true)
 	//}
 
-	return am.PostManager.postConfig(unifiedDecl, tenants)
+	posted, event := am.PostManager.postConfig(unifiedDecl, tenants)
+	am.recordHistory(tempAS3Config, "")
+	return posted, event
 }
 
 // configDeployer blocks on ReqChan
@@ -215,9 +268,28 @@ func (am *AS3Manager) postAS3Config(tempAS3Config AS3Config) (bool, string) {
 func (am *AS3Manager) ConfigDeployer() {
 	// For the very first post after starting controller, need not wait to post
 	log.Info("[INFO] running config deployer")
+	defer close(am.deployerStopped)
 	firstPost := true
 	am.unprocessableEntityStatus = false
-	for msgReq := range am.ReqChan {
+	// The tenant is compared with the BIG-IP between declarations
+	var driftC <-chan time.Time
+	if am.driftCheckInterval > 0 {
+		ticker := time.NewTicker(time.Duration(am.driftCheckInterval) * time.Second)
+		defer ticker.Stop()
+		driftC = ticker.C
+	}
+	for {
+		var msgReq AS3Config
+		select {
+		case req, ok := <-am.ReqChan:
+			if !ok {
+				return
+			}
+			msgReq = am.nextGeneration(req)
+		case <-driftC:
+			am.checkDrift()
+			continue
+		}
 		log.Info("[INFO] received new config")
 		if !firstPost && am.PostManager.AS3PostDelay != 0 {
 			// Time (in seconds) that CIS waits to post the AS3 declaration to BIG-IP.
@@ -227,7 +299,10 @@ func (am *AS3Manager) ConfigDeployer() {
 
 		// After postDelay expires pick up latest declaration, if available
 		select {
-		case msgReq = <-am.ReqChan:
+		case req, ok := <-am.ReqChan:
+			if ok {
+				msgReq = am.nextGeneration(req)
+			}
 		case <-time.After(1 * time.Microsecond):
 		}
 
@@ -235,13 +310,19 @@ func (am *AS3Manager) ConfigDeployer() {
 		// To handle general errors
 		for !posted {
 			am.unprocessableEntityStatus = true
+			if event == responseStatusUnprocessableEntity {
+				am.rollback(msgReq)
+			}
 			timeout := getTimeDurationForErrorResponse(event)
 			log.Debugf("[AS3] Error handling for event %v", event)
-			posted, event = am.postOnEventOrTimeout(timeout)
+			msgReq, posted, event = am.postOnEventOrTimeout(timeout, msgReq)
 		}
 		firstPost = false
 		if event == responseStatusOk {
 			am.unprocessableEntityStatus = false
+			am.as3ActiveConfig = msgReq
+			log.Infof("[AS3] Generation %d (%s) is live on tenant %s",
+				msgReq.Generation, msgReq.Declaration.ID, msgReq.Declaration.Tenant.Name)
 			log.Debugf("[AS3] Preparing response message to response handler")
 			//am.SendARPEntries()
 			//am.SendAgentResponse()
@@ -250,23 +331,58 @@ func (am *AS3Manager) ConfigDeployer() {
 	}
 }
 
-// Helper method used by configDeployer to handle error responses received from BIG-IP
-func (am *AS3Manager) postOnEventOrTimeout(timeout time.Duration) (bool, string) {
+// Helper method used by configDeployer to handle error responses received from BIG-IP.
+// A newer declaration replaces the failed one, otherwise the failed one is
+// posted again to its tenant once the timeout expires.
+func (am *AS3Manager) postOnEventOrTimeout(timeout time.Duration, failed AS3Config) (AS3Config, bool, string) {
 	select {
-	case msgReq := <-am.ReqChan:
-		return am.postAS3Declaration(msgReq)
-	case <-time.After(timeout):
-		var tenants []string = nil
-		//if am.FilterTenants {
-		//	tenants = []{""} //getTenants(am.as3ActiveConfig, true)
-		//}
-		myJson, err := json.Marshal(am.as3ActiveConfig)
-		if err != nil {
-			log.Error(err)
+	case msgReq, ok := <-am.ReqChan:
+		if !ok {
+			return failed, true, ""
 		}
-		unifiedDeclaration := string(myJson)
-		return am.PostManager.postConfig(unifiedDeclaration, tenants)
+		msgReq = am.nextGeneration(msgReq)
+		posted, event := am.postAS3Declaration(msgReq)
+		return msgReq, posted, event
+	case <-time.After(timeout):
+		posted, event := am.postAS3Declaration(failed)
+		return failed, posted, event
+	}
+}
+
+// nextGeneration numbers a declaration received on ReqChan
+func (am *AS3Manager) nextGeneration(req AS3Config) AS3Config {
+	am.generation++
+	req.Generation = am.generation
+	return req
+}
+
+// rollback restores the last applied declaration after AS3 rejected the
+// generation failed. The failed generation keeps being retried, a rejection
+// is only rolled back once.
+func (am *AS3Manager) rollback(failed AS3Config) {
+	tenant := failed.Declaration.Tenant.Name
+	if am.rolledBack == failed.Generation {
+		return
+	}
+	am.rolledBack = failed.Generation
+	if am.as3ActiveConfig.JsonObj == "" {
+		log.Errorf("[AS3] Generation %d was rejected and no generation was applied to tenant %s yet, nothing to roll back to",
+			failed.Generation, tenant)
+		return
+	}
+
+	live := am.as3ActiveConfig
+	log.Errorf("[AS3] Generation %d was rejected, rolling back tenant %s to generation %d (%s)",
+		failed.Generation, tenant, live.Generation, live.Declaration.ID)
+	posted, event := am.PostManager.postConfig(live.JsonObj, []string{tenant})
+	am.recordHistory(live, historyRollback)
+	if !posted || event != responseStatusOk {
+		log.Errorf("[AS3] Unable to roll back tenant %s to generation %d, the live generation is unknown",
+			tenant, live.Generation)
+		return
 	}
+	log.Infof("[AS3] Generation %d (%s) is live on tenant %s, retrying generation %d",
+		live.Generation, live.Declaration.ID, tenant, failed.Generation)
 }
 
 // Method to verify if App Services are installed or CIS as3 version is
@@ -281,20 +397,39 @@ func (am *AS3Manager) IsBigIPAppServicesAvailable() error {
 		log.Errorf("[AS3] %v ", err)
 		return err
 	}
-	versionstr := version[:strings.LastIndex(version, ".")]
-	bigIPVersion, err := strconv.ParseFloat(versionstr, 64)
+	bigIPVersion, err := ParseVersion(version)
 	if err != nil {
-		log.Errorf("[AS3] Error while converting AS3 version to float")
+		log.Errorf("[AS3] Error while parsing AS3 version: %v", err)
 		return err
 	}
-	if bigIPVersion >= as3SupportedVersion {
-		log.Debugf("[AS3] BIGIP is serving with AS3 version: %v", version)
-		return nil
+	if bigIPVersion.Less(as3SupportedVersion) {
+		return fmt.Errorf("bigip-tgw is compatible with AS3 versions >= %v. "+
+			"Upgrade AS3 version in BIGIP from %v to %v or above.", as3SupportedVersion,
+			bigIPVersion, as3SupportedVersion)
 	}
+	log.Debugf("[AS3] BIGIP is serving with AS3 version: %v", version)
 
-	return fmt.Errorf("CIS versions >= 2.0 are compatible with AS3 versions >= %v. "+
-		"Upgrade AS3 version in BIGIP from %v to %v or above.", as3SupportedVersion,
-		bigIPVersion, as3SupportedVersion)
+	am.schemaVersion = bigIPVersion
+	if am.configuredSchemaVersion != "" {
+		configured, err := ParseVersion(am.configuredSchemaVersion)
+		if err != nil {
+			return fmt.Errorf("invalid schemaVersion: %v", err)
+		}
+		if bigIPVersion.Less(configured) {
+			return fmt.Errorf("schemaVersion %v is newer than AS3 version %v of the BIGIP", configured, bigIPVersion)
+		}
+		if configured.Less(as3SupportedVersion) {
+			return fmt.Errorf("schemaVersion %v is older than %v, the oldest schemaVersion bigip-tgw declarations are valid in", configured, as3SupportedVersion)
+		}
+		am.schemaVersion = configured
+	}
+	log.Infof("[AS3] Declaring AS3 schemaVersion %v", am.schemaVersion)
+	return nil
+}
+
+// SchemaVersion is the schemaVersion of the declarations posted to the BIG-IP
+func (am *AS3Manager) SchemaVersion() Version {
+	return am.schemaVersion
 }
 
 func DeepEqualJSON(decl1, decl2 string) bool {
@@ -318,21 +453,22 @@ func DeepEqualJSON(decl1, decl2 string) bool {
 
 // Validates the AS3 Template
 func (am *AS3Manager) validateAS3Template(template string) bool {
-	var schemaLoader gojsonschema.JSONLoader
-	// Load AS3 Schema
-	schemaLoader = gojsonschema.NewReferenceLoader(am.As3SchemaLatest)
+	if am.as3Schema == nil {
+		log.Errorf("[AS3] AS3 schema %s is not loaded", am.As3SchemaLatest)
+		return false
+	}
 	// Load AS3 Template
 	documentLoader := gojsonschema.NewStringLoader(template)
-	result, err := gojsonschema.Validate(schemaLoader, documentLoader)
+	result, err := am.as3Schema.Validate(documentLoader)
 	if err != nil {
 		log.Errorf("%s", err)
 		return false
 	}
 
 	if !result.Valid() {
-		log.Errorf("[AS3] Template is not valid. see errors")
+		log.Errorf("[AS3] Template is not valid against AS3 schema %s, not posting it. see errors", am.As3SchemaLatest)
 		for _, desc := range result.Errors() {
-			log.Errorf("- %s\n", desc)
+			log.Errorf("- %s: %s", schemaErrorPointer(desc), desc.Description())
 		}
 		return false
 	}
@@ -1,13 +1,19 @@
 package as3
 
+import "encoding/json"
+
 type (
 	AS3Config struct {
-		Schema      string      `json:"$schema"`
+		Schema      string      `json:"$schema,omitempty"`
 		Class       string      `json:"class"`
 		Action      string      `json:"action"`
 		Persist     bool        `json:"persist"`
 		Declaration Declaration `json:"declaration"`
 		JsonObj     string      `json:"-"`
+		// Generation numbers the declarations received by the AS3Manager
+		Generation int `json:"-"`
+		// ConsulIndexes identify the Consul state the declaration was made from
+		ConsulIndexes map[string]uint64 `json:"-"`
 	}
 
 	Declaration struct {
@@ -17,7 +23,8 @@ type (
 		Label         string    `json:"label"`
 		Remark        string    `json:"remark"`
 		Controls      *Controls `json:"controls,omitempty"`
-		Tenant        Tenant    `json:"TGW_Tenant"`
+		// Tenant is rendered under its Name
+		Tenant Tenant `json:"-"`
 	}
 
 	Controls struct {
@@ -26,10 +33,13 @@ type (
 	}
 
 	Tenant struct {
-		Name               string                 `json:"-"`
-		Class              string                 `json:"class"`
-		DefaultRouteDomain int                    `json:"defaultRouteDomain"`
-		Application        map[string]interface{} `json:"TermatingGateway"`
+		Name               string `json:"-"`
+		Class              string `json:"class"`
+		Label              string `json:"label,omitempty"`
+		DefaultRouteDomain int    `json:"defaultRouteDomain"`
+		// Application is rendered under ApplicationName
+		ApplicationName string                 `json:"-"`
+		Application     map[string]interface{} `json:"-"`
 	}
 
 	Service struct {
@@ -59,21 +69,28 @@ type (
 		Members              []Member          `json:"members"`
 		Monitors             []ResourcePointer `json:"monitors"`
 		LoadBalancingMode    string            `json:"loadBalancingMode,omitempty"`
-		MinimumMembersActive int               `json:"minimumMembersActive,omitempty"`
-		ReselectTries        int               `json:"reselectTries,omitempty"`
+		MinimumMembersActive *int              `json:"minimumMembersActive,omitempty"`
+		ReselectTries        *int              `json:"reselectTries,omitempty"`
 		ServiceDownAction    string            `json:"serviceDownAction,omitempty"`
-		SlowRampTime         int               `json:"slowRampTime,omitempty"`
+		SlowRampTime         *int              `json:"slowRampTime,omitempty"`
 		MinimumMonitors      int               `json:"minimumMonitors,omitempty"`
 	}
 
 	Member struct {
 		ServicePort      int      `json:"servicePort"`
-		ServerAddresses  []string `json:"serverAddresses"`
+		ServerAddresses  []string `json:"serverAddresses,omitempty"`
 		AddressDiscovery string   `json:"addressDiscovery,omitempty"`
+		Hostname         string   `json:"hostname,omitempty"`
+		AutoPopulate     bool     `json:"autoPopulate,omitempty"`
+		QueryInterval    int      `json:"queryInterval,omitempty"`
+		AdminState       string   `json:"adminState,omitempty"`
+		Ratio            *int     `json:"ratio,omitempty"`
 	}
 
 	Monitor struct {
+		Name              string  `json:"-"`
 		Class             string  `json:"class,omitempty"`
+		Remark            string  `json:"remark,omitempty"`
 		Interval          int     `json:"interval,omitempty"`
 		MonitorType       string  `json:"monitorType,omitempty"`
 		TargetAddress     *string `json:"targetAddress,omitempty"`
@@ -117,12 +134,13 @@ type (
 		IgnoreExpired       bool   `json:"ignoreExpired,omitempty"`
 		IgnoreUntrusted     bool   `json:"ignoreUntrusted,omitempty"`
 		SessionTickets      bool   `json:"sessionTickets,omitempty"`
-		ClientCertificate   string `json:"clientCertificate"`
+		ClientCertificate   string `json:"clientCertificate,omitempty"`
 	}
 
 	CABundle struct {
 		Name   string `json:"-"`
 		Class  string `json:"class"`
+		Remark string `json:"remark,omitempty"`
 		Bundle string `json:"bundle"`
 	}
 	CertName struct {
@@ -132,9 +150,10 @@ type (
 	Certificate struct {
 		Name        string `json:"-"`
 		Class       string `json:"class"`
+		Remark      string `json:"remark,omitempty"`
 		Certificate string `json:"certificate"`
 		PrivateKey  string `json:"privateKey"`
-		ChainCA     string `json:"chainCA"`
+		ChainCA     string `json:"chainCA,omitempty"`
 	}
 
 	PolicyEndpoint struct {
@@ -221,3 +240,49 @@ type (
 		Value string `json:"value"`
 	}
 )
+
+// MarshalJSON renders the tenant under its configured name
+func (d Declaration) MarshalJSON() ([]byte, error) {
+	type declaration Declaration
+	obj, err := json.Marshal(declaration(d))
+	if err != nil {
+		return nil, err
+	}
+	if d.Tenant.Name == "" {
+		return obj, nil
+	}
+
+	decl := make(map[string]json.RawMessage)
+	err = json.Unmarshal(obj, &decl)
+	if err != nil {
+		return nil, err
+	}
+	decl[d.Tenant.Name], err = json.Marshal(d.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(decl)
+}
+
+// MarshalJSON renders the application under its configured name
+func (t Tenant) MarshalJSON() ([]byte, error) {
+	type tenant Tenant
+	obj, err := json.Marshal(tenant(t))
+	if err != nil {
+		return nil, err
+	}
+	if t.ApplicationName == "" {
+		return obj, nil
+	}
+
+	ten := make(map[string]json.RawMessage)
+	err = json.Unmarshal(obj, &ten)
+	if err != nil {
+		return nil, err
+	}
+	ten[t.ApplicationName], err = json.Marshal(t.Application)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ten)
+}
@@ -0,0 +1,144 @@
+package as3
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLoginProvider = "tmos"
+	// tokenRefreshMargin renews a token before the BIG-IP expires it
+	tokenRefreshMargin = 1 * time.Minute
+	// defaultTokenTimeout applies when the BIG-IP does not report one
+	defaultTokenTimeout = 20 * time.Minute
+)
+
+// authToken is the X-F5-Auth-Token shared by the requests of a PostManager
+type authToken struct {
+	lock    sync.Mutex
+	token   string
+	expires time.Time
+}
+
+type loginResponse struct {
+	Token struct {
+		Token   string `json:"token"`
+		Timeout int    `json:"timeout"`
+	} `json:"token"`
+}
+
+func (postMgr *PostManager) getLoginURL() string {
+	return postMgr.BIGIPURL + "/mgmt/shared/authn/login"
+}
+
+// getToken returns a valid token, logging in again when the current one is
+// missing or about to expire
+func (postMgr *PostManager) getToken() (string, error) {
+	postMgr.auth.lock.Lock()
+	defer postMgr.auth.lock.Unlock()
+	if postMgr.auth.token != "" && time.Now().Add(tokenRefreshMargin).Before(postMgr.auth.expires) {
+		return postMgr.auth.token, nil
+	}
+
+	token, timeout, err := postMgr.login()
+	if err != nil {
+		postMgr.auth.token = ""
+		return "", err
+	}
+	postMgr.auth.token = token
+	postMgr.auth.expires = time.Now().Add(timeout)
+	log.Debugf("[AS3] Obtained BIG-IP auth token for %s, valid for %v", postMgr.BIGIPUsername, timeout)
+	return token, nil
+}
+
+// invalidateToken drops a token rejected by the BIG-IP, unless another
+// request already replaced it
+func (postMgr *PostManager) invalidateToken(token string) {
+	postMgr.auth.lock.Lock()
+	defer postMgr.auth.lock.Unlock()
+	if postMgr.auth.token == token {
+		postMgr.auth.token = ""
+	}
+}
+
+// login authenticates against the configured login provider. The password
+// is only sent in the request body, it is never part of an error or a log.
+func (postMgr *PostManager) login() (string, time.Duration, error) {
+	provider := postMgr.BIGIPLoginProvider
+	if provider == "" {
+		provider = defaultLoginProvider
+	}
+	body, err := json.Marshal(map[string]string{
+		"username":          postMgr.BIGIPUsername,
+		"password":          postMgr.BIGIPPassword,
+		"loginProviderName": provider,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	req, err := http.NewRequest("POST", postMgr.getLoginURL(), bytes.NewReader(body))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	log.Debugf("[AS3] Logging in to BIG-IP as %s with login provider %s", postMgr.BIGIPUsername, provider)
+	httpResp, err := postMgr.httpClient.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("BIG-IP login failed: %v", err)
+	}
+	defer httpResp.Body.Close()
+	data, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return "", 0, fmt.Errorf("BIG-IP login failed: %v", err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("BIG-IP login as %s with login provider %s failed with status code %v", postMgr.BIGIPUsername, provider, httpResp.StatusCode)
+	}
+
+	var rsp loginResponse
+	if err := json.Unmarshal(data, &rsp); err != nil || rsp.Token.Token == "" {
+		return "", 0, fmt.Errorf("BIG-IP login response does not contain a token")
+	}
+	timeout := time.Duration(rsp.Token.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultTokenTimeout
+	}
+	return rsp.Token.Token, timeout, nil
+}
+
+// do sends an authenticated request. A token rejected with 401 is dropped
+// and the request is sent once more with a new one.
+func (postMgr *PostManager) do(req *http.Request) (*http.Response, error) {
+	token, err := postMgr.getToken()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-F5-Auth-Token", token)
+	httpResp, err := postMgr.httpClient.Do(req)
+	if err != nil || httpResp.StatusCode != http.StatusUnauthorized {
+		return httpResp, err
+	}
+	httpResp.Body.Close()
+
+	log.Debugf("[AS3] BIG-IP rejected the auth token, logging in again")
+	postMgr.invalidateToken(token)
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	token, err = postMgr.getToken()
+	if err != nil {
+		return nil, err
+	}
+	retry.Header.Set("X-F5-Auth-Token", token)
+	return postMgr.httpClient.Do(retry)
+}
@@ -0,0 +1,208 @@
+package as3
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	driftAdded   = "added"
+	driftRemoved = "removed"
+	driftChanged = "changed"
+	// driftValueLength truncates values in the drift log, certificates
+	// would fill it otherwise
+	driftValueLength = 64
+)
+
+// driftChange is a difference between the declaration posted for a tenant
+// and the one the BIG-IP holds. Path is a JSON pointer below the tenant.
+type driftChange struct {
+	Kind    string
+	Path    string
+	Desired interface{}
+	Live    interface{}
+}
+
+// checkDrift compares the tenant of the active declaration with the one
+// on the BIG-IP. Differences are logged and, with driftCorrect, the active
+// declaration is posted again.
+func (am *AS3Manager) checkDrift() {
+	tenant := am.as3ActiveConfig.Declaration.Tenant.Name
+	if tenant == "" || am.as3ActiveConfig.JsonObj == "" || am.unprocessableEntityStatus {
+		return
+	}
+
+	desired, err := declaredTenant(am.as3ActiveConfig.JsonObj, tenant)
+	if err != nil {
+		log.Errorf("[AS3] Unable to check tenant %s for drift: %v", tenant, err)
+		return
+	}
+	raw, err := am.PostManager.getTenant(tenant)
+	if err != nil {
+		log.Errorf("[AS3] Unable to check tenant %s for drift: %v", tenant, err)
+		return
+	}
+
+	var changes []driftChange
+	if raw == nil {
+		changes = append(changes, driftChange{Kind: driftRemoved, Path: "/", Desired: desired})
+	} else {
+		var live interface{}
+		if err := json.Unmarshal(raw, &live); err != nil {
+			log.Errorf("[AS3] Unable to parse tenant %s for drift: %v", tenant, err)
+			return
+		}
+		diffJSON("", desired, live, &changes)
+	}
+	if len(changes) == 0 {
+		log.Debugf("[AS3] No drift in tenant %s", tenant)
+		return
+	}
+
+	log.Warnf("[AS3] Tenant %s on the BIG-IP drifted from the posted declaration, %d differences", tenant, len(changes))
+	for _, c := range changes {
+		log.Warnf("[AS3] drift tenant=%s op=%s path=%s desired=%s live=%s",
+			tenant, c.Kind, c.Path, driftValue(c.Desired), driftValue(c.Live))
+	}
+	if !am.driftCorrect {
+		return
+	}
+
+	log.Infof("[AS3] Correcting the drift of tenant %s", tenant)
+	posted, event := am.PostManager.postConfig(am.as3ActiveConfig.JsonObj, []string{tenant})
+	am.recordHistory(am.as3ActiveConfig, historyDriftCorrection)
+	if !posted || event != responseStatusOk {
+		log.Errorf("[AS3] Unable to correct the drift of tenant %s, retrying at the next check", tenant)
+	}
+}
+
+// declaredTenant extracts a tenant from a declaration
+func declaredTenant(jsonObj string, tenant string) (interface{}, error) {
+	var cfg struct {
+		Declaration map[string]interface{} `json:"declaration"`
+	}
+	if err := json.Unmarshal([]byte(jsonObj), &cfg); err != nil {
+		return nil, err
+	}
+	desired, ok := cfg.Declaration[tenant]
+	if !ok {
+		return nil, fmt.Errorf("the declaration does not contain tenant %s", tenant)
+	}
+	return desired, nil
+}
+
+// diffJSON collects the differences between two decoded JSON documents.
+// AS3 returns secrets such as private keys encrypted, they cannot be compared
+// and are skipped.
+func diffJSON(path string, desired, live interface{}, changes *[]driftChange) {
+	if isEncrypted(live) {
+		return
+	}
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(d)+len(l))
+		for k := range d {
+			keys = append(keys, k)
+		}
+		for k := range l {
+			if _, ok := d[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := path + "/" + escapePointer(k)
+			dv, inDesired := d[k]
+			lv, inLive := l[k]
+			switch {
+			case !inLive:
+				*changes = append(*changes, driftChange{Kind: driftRemoved, Path: p, Desired: dv})
+			case !inDesired:
+				*changes = append(*changes, driftChange{Kind: driftAdded, Path: p, Live: lv})
+			default:
+				diffJSON(p, dv, lv, changes)
+			}
+		}
+		return
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(d) || i < len(l); i++ {
+			p := path + "/" + strconv.Itoa(i)
+			switch {
+			case i >= len(l):
+				*changes = append(*changes, driftChange{Kind: driftRemoved, Path: p, Desired: d[i]})
+			case i >= len(d):
+				*changes = append(*changes, driftChange{Kind: driftAdded, Path: p, Live: l[i]})
+			default:
+				diffJSON(p, d[i], l[i], changes)
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(desired, live) {
+		if path == "" {
+			path = "/"
+		}
+		*changes = append(*changes, driftChange{Kind: driftChanged, Path: path, Desired: desired, Live: live})
+	}
+}
+
+// isEncrypted recognizes the secrets AS3 stores as JWE
+func isEncrypted(v interface{}) bool {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, ok = m["ciphertext"]
+	return ok
+}
+
+func escapePointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+func driftValue(v interface{}) string {
+	if v == nil {
+		return "-"
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	s := string(b)
+	if len(s) > driftValueLength {
+		s = s[:driftValueLength] + "..."
+	}
+	return s
+}
+
+// DiffTenant lists the differences of a tenant between two declarations,
+// one line per difference
+func DiffTenant(from string, to string, tenant string) ([]string, error) {
+	fromTenant, err := declaredTenant(from, tenant)
+	if err != nil {
+		return nil, err
+	}
+	toTenant, err := declaredTenant(to, tenant)
+	if err != nil {
+		return nil, err
+	}
+	var changes []driftChange
+	diffJSON("", fromTenant, toTenant, &changes)
+	lines := make([]string, 0, len(changes))
+	for _, c := range changes {
+		lines = append(lines, fmt.Sprintf("%s %s: %s -> %s", c.Kind, c.Path, driftValue(c.Desired), driftValue(c.Live)))
+	}
+	return lines, nil
+}
@@ -0,0 +1,65 @@
+package as3
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/f5devcentral/bigip-tgw/history"
+)
+
+// EnableHistory saves the declarations posted for tenant to store. The
+// generations continue from the newest one saved.
+func (am *AS3Manager) EnableHistory(store *history.Store, tenant string) error {
+	latest, err := store.Latest(tenant)
+	if err != nil {
+		return fmt.Errorf("unable to read the history of tenant %s: %v", tenant, err)
+	}
+	am.history = store
+	am.generation = latest
+	log.Infof("[AS3] Saving the declarations of tenant %s to the history, continuing after generation %d", tenant, latest)
+	return nil
+}
+
+// History reasons of the posts that restore a generation
+const (
+	historyRollback        = "rollback"
+	historyDriftCorrection = "drift-correction"
+)
+
+// recordHistory saves a posted declaration with the code AS3 answered with.
+// reason tells why a generation was posted again, it is empty for the posts
+// of ConfigDeployer and their retries.
+func (am *AS3Manager) recordHistory(cfg AS3Config, reason string) {
+	if am.history == nil {
+		return
+	}
+	entry := history.Entry{
+		Generation:    cfg.Generation,
+		Tenant:        cfg.Declaration.Tenant.Name,
+		Time:          time.Now().UTC(),
+		Hash:          strings.TrimPrefix(cfg.Declaration.ID, "tgw-"),
+		Code:          am.PostManager.lastCode,
+		Reason:        reason,
+		ConsulIndexes: cfg.ConsulIndexes,
+		Declaration:   json.RawMessage(cfg.JsonObj),
+	}
+	if err := am.history.Save(entry); err != nil {
+		log.Errorf("[AS3] Unable to save generation %d to the history: %v", cfg.Generation, err)
+	}
+}
+
+// ApplyDeclaration posts a declaration saved in the history to its tenant
+func (am *AS3Manager) ApplyDeclaration(jsonObj string, tenant string) error {
+	if am.as3Validation {
+		if ok := am.validateAS3Template(jsonObj); !ok {
+			return fmt.Errorf("the declaration is not valid against AS3 schema %s", am.As3SchemaLatest)
+		}
+	}
+	posted, event := am.PostManager.postConfig(jsonObj, []string{tenant})
+	if !posted || event != responseStatusOk {
+		return fmt.Errorf("the BIG-IP did not apply the declaration to tenant %s, AS3 responded with code %d", tenant, am.PostManager.lastCode)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package as3
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// OwnerLabel is the label of the AS3 tenants managed by bigip-tgw, tenants
+// without it belong to someone else and are never overwritten or deleted
+const OwnerLabel = "bigip-tgw"
+
+// legacyTenant and legacyApplication are the names used by the releases of
+// bigip-tgw that did not label their tenant
+const (
+	legacyTenant      = "TGW_Tenant"
+	legacyApplication = "TermatingGateway"
+)
+
+// CheckTenantOwnership returns an error when the tenant exists on the BIG-IP
+// without the bigip-tgw label. A missing tenant is free to be created, and
+// the unlabelled tenant of previous releases is taken over, the next post
+// labels it.
+func (postMgr *PostManager) CheckTenantOwnership(tenant string) error {
+	log.Debugf("[AS3] checking ownership of tenant %s", tenant)
+	raw, err := postMgr.getTenant(tenant)
+	if err != nil || raw == nil {
+		return err
+	}
+	var existing struct {
+		Label       string          `json:"label"`
+		Application json.RawMessage `json:"TermatingGateway"`
+	}
+	if err := json.Unmarshal(raw, &existing); err != nil {
+		return fmt.Errorf("unable to parse tenant %s: %v", tenant, err)
+	}
+	if tenant == legacyTenant && existing.Label == "" && existing.Application != nil {
+		log.Warnf("[AS3] Taking over tenant %s of a previous bigip-tgw release, application %s is replaced", tenant, legacyApplication)
+		return nil
+	}
+	if existing.Label != OwnerLabel {
+		return fmt.Errorf("tenant %s already exists on the BIG-IP and is not managed by bigip-tgw", tenant)
+	}
+	return nil
+}
+
+// getTenant reads the declaration of a tenant from the BIG-IP, it is nil
+// when the tenant does not exist
+func (postMgr *PostManager) getTenant(tenant string) (json.RawMessage, error) {
+	url := postMgr.getAS3APIURL([]string{tenant})
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("[AS3] reading tenant %s on %v", tenant, url)
+
+	httpResp, err := postMgr.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read tenant %s: %v", tenant, err)
+	}
+	defer httpResp.Body.Close()
+
+	switch httpResp.StatusCode {
+	case http.StatusNoContent, http.StatusNotFound:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("unable to read tenant %s, BIG-IP responded with status code %v", tenant, httpResp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read tenant %s: %v", tenant, err)
+	}
+	var declaration map[string]json.RawMessage
+	if err := json.Unmarshal(body, &declaration); err != nil {
+		return nil, fmt.Errorf("unable to parse the declaration of tenant %s: %v", tenant, err)
+	}
+	return declaration[tenant], nil
+}
@@ -24,22 +24,35 @@ const (
 	responseStatusCommon             = "statusCommonResponse"
 	responseStatusNotFound           = "statusNotFound"
 	responseStatusServiceUnavailable = "statusServiceUnavailable"
+	// responseStatusUnprocessableEntity is the rejection of a declaration
+	// or of its tenant by AS3, posting it again fails the same way
+	responseStatusUnprocessableEntity = "statusUnprocessableEntity"
 )
 
 type PostManager struct {
 	postChan   chan configData
 	httpClient *http.Client
+	auth       authToken
 	activeCfg  configData
+	// lastCode is the code of the response to the last post, 0 without
+	// response
+	lastCode int
 	PostParams
 }
 
 type PostParams struct {
-	BIGIPUsername string
-	BIGIPPassword string
-	BIGIPURL      string
-	TrustedCerts  string
-	SSLInsecure   bool
-	AS3PostDelay  int
+	BIGIPUsername      string
+	BIGIPPassword      string
+	BIGIPLoginProvider string
+	BIGIPURL           string
+	TrustedCerts       string
+	SSLInsecure        bool
+	AS3PostDelay       int
+	// AS3AsyncPost posts with async=true and polls the AS3 task until
+	// AS3TaskDeadline, every AS3TaskPollInterval seconds
+	AS3AsyncPost        bool
+	AS3TaskPollInterval int
+	AS3TaskDeadline     int
 	//Log the AS3 response body in Controller logs
 	LogResponse bool
 	//RouteClientV1 routeclient.RouteV1Interface
@@ -92,6 +105,14 @@ func (postMgr *PostManager) getAS3APIURL(tenants []string) string {
 	return apiURL
 }
 
+func (postMgr *PostManager) getAS3PostURL(tenants []string) string {
+	apiURL := postMgr.getAS3APIURL(tenants)
+	if postMgr.AS3AsyncPost {
+		apiURL += "?async=true"
+	}
+	return apiURL
+}
+
 func (postMgr *PostManager) getAS3VersionURL() string {
 	apiURL := postMgr.BIGIPURL + "/mgmt/shared/appsvcs/info"
 	return apiURL
@@ -104,14 +125,22 @@ func getTimeDurationForErrorResponse(errRsp string) time.Duration {
 		duration = timeoutMedium
 	case responseStatusServiceUnavailable:
 		duration = timeoutSmall
+	case responseStatusUnprocessableEntity:
+		duration = timeoutMedium
 	}
 	return duration
 }
 
 func (postMgr *PostManager) postConfig(data string, tenants []string) (bool, string) {
+	// Without a tenant filter AS3 replaces every tenant of the BIG-IP
+	if len(tenants) == 0 {
+		log.Errorf("[AS3] Refusing to post a declaration without tenant")
+		return true, responseStatusCommon
+	}
+	postMgr.lastCode = 0
 	cfg := configData{
 		data:      data,
-		as3APIURL: postMgr.getAS3APIURL(tenants),
+		as3APIURL: postMgr.getAS3PostURL(tenants),
 	}
 	httpReqBody := bytes.NewBuffer([]byte(cfg.data))
 
@@ -121,25 +150,54 @@ func (postMgr *PostManager) postConfig(data string, tenants []string) (bool, str
 		return false, responseStatusCommon
 	}
 	log.Debugf("[AS3] posting request to %v", cfg.as3APIURL)
-	req.SetBasicAuth(postMgr.BIGIPUsername, postMgr.BIGIPPassword)
 
 	httpResp, responseMap := postMgr.httpReq(req)
 	if httpResp == nil || responseMap == nil {
 		return false, responseStatusCommon
 	}
+	postMgr.lastCode = responseCode(httpResp.StatusCode, responseMap)
 
 	switch httpResp.StatusCode {
-	case http.StatusOK, http.StatusCreated, http.StatusAccepted:
+	case http.StatusAccepted:
+		if postMgr.AS3AsyncPost {
+			id, err := taskID(responseMap)
+			if err != nil {
+				log.Errorf("[AS3] %v", err)
+				return false, responseStatusCommon
+			}
+			return postMgr.pollTask(id, cfg)
+		}
+		return postMgr.handleResponseStatusOK(responseMap, cfg)
+	case http.StatusOK, http.StatusCreated:
 		return postMgr.handleResponseStatusOK(responseMap, cfg)
 	case http.StatusServiceUnavailable:
 		return postMgr.handleResponseStatusServiceUnavailable(responseMap, cfg)
 	case http.StatusNotFound:
 		return postMgr.handleResponseStatusNotFound(responseMap)
+	case http.StatusUnprocessableEntity, http.StatusMultiStatus:
+		return postMgr.handleResponseStatusUnprocessableEntity(responseMap, cfg)
 	default:
 		return postMgr.handleResponseOthers(responseMap, cfg)
 	}
 }
 
+// responseCode is the code AS3 answered a post with, the highest code of the
+// tenant results when there are any and the HTTP status code otherwise
+func responseCode(status int, responseMap map[string]interface{}) int {
+	code := 0
+	results, _ := (responseMap["results"]).([]interface{})
+	for _, value := range results {
+		v, _ := value.(map[string]interface{})
+		if c, ok := v["code"].(float64); ok && int(c) > code {
+			code = int(c)
+		}
+	}
+	if code == 0 {
+		return status
+	}
+	return code
+}
+
 func (postMgr *PostManager) GetBigipAS3Version() (string, string, error) {
 	url := postMgr.getAS3VersionURL()
 	req, err := http.NewRequest("GET", url, nil)
@@ -149,7 +207,6 @@ func (postMgr *PostManager) GetBigipAS3Version() (string, string, error) {
 	}
 
 	log.Debugf("[AS3] posting GET BIGIP AS3 Version request on %v", url)
-	req.SetBasicAuth(postMgr.BIGIPUsername, postMgr.BIGIPPassword)
 
 	httpResp, responseMap := postMgr.httpReq(req)
 	if httpResp == nil || responseMap == nil {
@@ -176,7 +233,7 @@ func (postMgr *PostManager) GetBigipAS3Version() (string, string, error) {
 }
 
 func (postMgr *PostManager) httpReq(request *http.Request) (*http.Response, map[string]interface{}) {
-	httpResp, err := postMgr.httpClient.Do(request)
+	httpResp, err := postMgr.do(request)
 	if err != nil {
 		log.Errorf("[AS3] REST call error: %v ", err)
 		return nil, nil
@@ -251,7 +308,16 @@ func (postMgr *PostManager) handleResponseOthers(responseMap map[string]interfac
 	return false, responseStatusCommon
 }
 
+func (postMgr *PostManager) handleResponseStatusUnprocessableEntity(responseMap map[string]interface{}, cfg configData) (bool, string) {
+	postMgr.handleResponseOthers(responseMap, cfg)
+	return false, responseStatusUnprocessableEntity
+}
+
 func (postMgr *PostManager) DeletePartition(tenants []string) error {
+	// Without a tenant filter AS3 deletes every tenant of the BIG-IP
+	if len(tenants) == 0 {
+		return fmt.Errorf("no tenant to delete")
+	}
 	url := postMgr.getAS3APIURL(tenants)
 	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
@@ -260,7 +326,6 @@ func (postMgr *PostManager) DeletePartition(tenants []string) error {
 	}
 
 	log.Debugf("[AS3] Deleting AS3 Partition on %v", url)
-	req.SetBasicAuth(postMgr.BIGIPUsername, postMgr.BIGIPPassword)
 
 	httpResp, responseMap := postMgr.httpReq(req)
 	if httpResp == nil || responseMap == nil {
@@ -0,0 +1,90 @@
+package as3
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// schemaFile matches the schema files published with AS3, e.g.
+// as3-schema-3.24.0-5.json
+var schemaFile = regexp.MustCompile(`^as3-schema-(\d+\.\d+\.\d+)(-\d+)?\.json$`)
+
+// findSchema picks the schema of the AS3 version running on the BIG-IP from
+// the schemas below dir. Without an exact match the newest older schema is
+// used, AS3 accepts the declarations of previous schema versions.
+func findSchema(dir string, as3Version string) (string, error) {
+	target, err := ParseVersion(as3Version)
+	if err != nil {
+		return "", err
+	}
+	var best string
+	var bestVersion Version
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		m := schemaFile.FindStringSubmatch(info.Name())
+		if info.IsDir() || m == nil {
+			return nil
+		}
+		v, err := ParseVersion(m[1])
+		if err != nil || target.Less(v) {
+			return nil
+		}
+		if best == "" || bestVersion.Less(v) || (bestVersion == v && path > best) {
+			best, bestVersion = path, v
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if best == "" {
+		return "", fmt.Errorf("no AS3 schema for version %s or older in %s", as3Version, dir)
+	}
+	return best, nil
+}
+
+// loadAS3Schema compiles the schema used to validate declarations once, from
+// SchemaLocalPath. Schemas are never downloaded, validation has to work on
+// BIG-IPs without internet access.
+func (am *AS3Manager) loadAS3Schema() error {
+	if am.schemaLocalPath == "" {
+		return fmt.Errorf("AS3Validation requires SchemaLocalPath, the directory holding the AS3 schemas")
+	}
+	path, err := findSchema(am.schemaLocalPath, am.as3Version)
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	am.As3SchemaLatest = abs
+	loader := gojsonschema.NewReferenceLoader("file://" + filepath.ToSlash(abs))
+	schema, err := gojsonschema.NewSchema(loader)
+	if err != nil {
+		return fmt.Errorf("loading AS3 schema %s: %v", am.As3SchemaLatest, err)
+	}
+	am.as3Schema = schema
+	log.Infof("[AS3] Validating declarations against AS3 schema %s", am.As3SchemaLatest)
+	return nil
+}
+
+// schemaErrorPointer turns the context of a validation error into a JSON
+// pointer to the offending value of the declaration
+func schemaErrorPointer(desc gojsonschema.ResultError) string {
+	if desc.Context() == nil {
+		return "/"
+	}
+	pointer := strings.TrimPrefix(desc.Context().String("/"), gojsonschema.STRING_CONTEXT_ROOT)
+	if pointer == "" {
+		return "/"
+	}
+	return pointer
+}
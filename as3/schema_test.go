@@ -0,0 +1,70 @@
+package as3
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "as3-schemas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{
+		"3.20.0/as3-schema-3.20.0-3.json",
+		"3.24.0/as3-schema-3.24.0-5.json",
+		"3.24.0/as3-schema-3.24.0-6.json",
+		"3.9.0/as3-schema-3.9.0-2.json",
+		"3.30.0/as3-schema-3.30.0-5.json",
+		"as3-schema-3.22.1.json",
+		"3.28.0/as3-schema-3.28.0-1.json.bak",
+		"3.28.0/schema.json",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		version string
+		want    string
+		wantErr bool
+	}{
+		// exact match, the newest build of the version wins
+		{version: "3.24.0", want: "3.24.0/as3-schema-3.24.0-6.json"},
+		{version: "3.30.0", want: "3.30.0/as3-schema-3.30.0-5.json"},
+		// newest older schema, versions are compared numerically
+		// files that are not schemas are ignored
+		{version: "3.29.1", want: "3.24.0/as3-schema-3.24.0-6.json"},
+		{version: "3.23.0", want: "as3-schema-3.22.1.json"},
+		{version: "3.21.0", want: "3.20.0/as3-schema-3.20.0-3.json"},
+		{version: "3.10.0", want: "3.9.0/as3-schema-3.9.0-2.json"},
+		{version: "3.40.0", want: "3.30.0/as3-schema-3.30.0-5.json"},
+		// nothing old enough
+		{version: "3.8.0", wantErr: true},
+		{version: "not-a-version", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := findSchema(dir, tt.version)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("findSchema(%s) = %s, want an error", tt.version, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("findSchema(%s) returned %v", tt.version, err)
+			continue
+		}
+		if got != filepath.Join(dir, tt.want) {
+			t.Errorf("findSchema(%s) = %s, want %s", tt.version, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package as3
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultTaskPollInterval = 1 * time.Second
+	defaultTaskDeadline     = 10 * time.Minute
+	taskInProgress          = "in progress"
+)
+
+func (postMgr *PostManager) getAS3TaskURL(id string) string {
+	return postMgr.BIGIPURL + "/mgmt/shared/appsvcs/task/" + id
+}
+
+func (postMgr *PostManager) taskPollInterval() time.Duration {
+	if postMgr.AS3TaskPollInterval > 0 {
+		return time.Duration(postMgr.AS3TaskPollInterval) * time.Second
+	}
+	return defaultTaskPollInterval
+}
+
+func (postMgr *PostManager) taskDeadline() time.Duration {
+	if postMgr.AS3TaskDeadline > 0 {
+		return time.Duration(postMgr.AS3TaskDeadline) * time.Second
+	}
+	return defaultTaskDeadline
+}
+
+// pollTask waits for the AS3 task of an asynchronous post and handles its
+// final record like the response of a synchronous post
+func (postMgr *PostManager) pollTask(id string, cfg configData) (bool, string) {
+	url := postMgr.getAS3TaskURL(id)
+	deadline := time.Now().Add(postMgr.taskDeadline())
+	log.Debugf("[AS3] Polling AS3 task %v", url)
+	for {
+		time.Sleep(postMgr.taskPollInterval())
+		if time.Now().After(deadline) {
+			log.Errorf("[AS3] AS3 task %s did not complete within %v", id, postMgr.taskDeadline())
+			return false, responseStatusCommon
+		}
+
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			log.Errorf("[AS3] Creating new HTTP request error: %v ", err)
+			return false, responseStatusCommon
+		}
+		httpResp, responseMap := postMgr.httpReq(req)
+		if httpResp == nil || responseMap == nil {
+			// The task keeps running on the BIG-IP, poll again
+			continue
+		}
+		postMgr.lastCode = responseCode(httpResp.StatusCode, responseMap)
+
+		switch httpResp.StatusCode {
+		case http.StatusOK:
+		case http.StatusServiceUnavailable:
+			log.Debugf("[AS3] BIG-IP is busy, polling AS3 task %s again", id)
+			continue
+		case http.StatusNotFound:
+			return postMgr.handleResponseStatusNotFound(responseMap)
+		default:
+			return postMgr.handleResponseOthers(responseMap, cfg)
+		}
+
+		done, failed, rejected := taskStatus(responseMap)
+		if !done {
+			continue
+		}
+		log.Debugf("[AS3] AS3 task %s completed", id)
+		switch {
+		case rejected:
+			return postMgr.handleResponseStatusUnprocessableEntity(responseMap, cfg)
+		case failed:
+			return postMgr.handleResponseOthers(responseMap, cfg)
+		}
+		return postMgr.handleResponseStatusOK(responseMap, cfg)
+	}
+}
+
+// taskStatus reports whether the per-tenant results of a task record are
+// final, whether any tenant failed and whether AS3 rejected a tenant
+func taskStatus(responseMap map[string]interface{}) (done bool, failed bool, rejected bool) {
+	results, ok := (responseMap["results"]).([]interface{})
+	if !ok || len(results) == 0 {
+		return false, false, false
+	}
+	for _, value := range results {
+		v, ok := value.(map[string]interface{})
+		if !ok {
+			return true, true, false
+		}
+		if v["message"] == taskInProgress {
+			return false, false, false
+		}
+		code, _ := v["code"].(float64)
+		switch int(code) {
+		case http.StatusOK:
+		case http.StatusUnprocessableEntity:
+			failed, rejected = true, true
+		default:
+			failed = true
+		}
+	}
+	return true, failed, rejected
+}
+
+// taskID returns the id of the task started by an asynchronous post
+func taskID(responseMap map[string]interface{}) (string, error) {
+	id, ok := responseMap["id"].(string)
+	if !ok || id == "" {
+		return "", fmt.Errorf("AS3 response does not contain a task id")
+	}
+	return id, nil
+}
@@ -0,0 +1,57 @@
+package as3
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is an AS3 version such as 3.24.0
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// ParseVersion reads semantic versions such as 3.24.0 or v3.24.0-5. A missing
+// patch defaults to 0, build and pre-release suffixes are ignored.
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	core := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexAny(core, "-+"); i >= 0 {
+		core = core[:i]
+	}
+	parts := strings.Split(core, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return v, fmt.Errorf("invalid AS3 version %q", s)
+	}
+	numbers := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid AS3 version %q", s)
+		}
+		*numbers[i] = n
+	}
+	return v, nil
+}
+
+// Less orders versions numerically, 3.9.0 is older than 3.20.0
+func (v Version) Less(o Version) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor < o.Minor
+	}
+	return v.Patch < o.Patch
+}
+
+// AtLeast reports whether v is o or newer
+func (v Version) AtLeast(o Version) bool {
+	return !v.Less(o)
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
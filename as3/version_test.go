@@ -0,0 +1,65 @@
+package as3
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Version
+		wantErr bool
+	}{
+		{in: "3.24.0", want: Version{3, 24, 0}},
+		{in: "3.9", want: Version{3, 9, 0}},
+		{in: "v3.20.1", want: Version{3, 20, 1}},
+		{in: "3.24.0-5", want: Version{3, 24, 0}},
+		{in: "3.30.0+build.2", want: Version{3, 30, 0}},
+		{in: " 3.23.0 ", want: Version{3, 23, 0}},
+		{in: "", wantErr: true},
+		{in: "3", wantErr: true},
+		{in: "3.x.0", wantErr: true},
+		{in: "3.24.0.1", wantErr: true},
+		{in: "3.-1.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseVersion(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseVersion(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseVersion(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestVersionOrder(t *testing.T) {
+	tests := []struct {
+		a, b string
+		less bool
+	}{
+		// compared numerically, not as floats or strings
+		{"3.9.0", "3.20.0", true},
+		{"3.20.0", "3.9.0", false},
+		{"3.2.0", "3.10.0", true},
+		{"3.23.0", "3.23.1", true},
+		{"3.23.1", "3.23.0", false},
+		{"2.99.99", "3.0.0", true},
+		{"3.24.0", "3.24.0", false},
+		{"3.24.0-5", "3.24.0-6", false},
+	}
+	for _, tt := range tests {
+		a, _ := ParseVersion(tt.a)
+		b, _ := ParseVersion(tt.b)
+		if got := a.Less(b); got != tt.less {
+			t.Errorf("%s.Less(%s) = %v, want %v", tt.a, tt.b, got, tt.less)
+		}
+		if got := a.AtLeast(b); got == tt.less {
+			t.Errorf("%s.AtLeast(%s) = %v, want %v", tt.a, tt.b, got, !tt.less)
+		}
+	}
+	if got := (Version{3, 20, 0}).String(); got != "3.20.0" {
+		t.Errorf("String() = %q", got)
+	}
+}
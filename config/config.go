@@ -2,31 +2,63 @@ package config
 
 import (
 	"fmt"
+	"os"
+	"regexp"
 	"strings"
 
 	"github.com/f5devcentral/bigip-tgw/as3"
 	"github.com/f5devcentral/bigip-tgw/consul"
+	"github.com/f5devcentral/bigip-tgw/gateway"
+	"github.com/f5devcentral/bigip-tgw/history"
 	"github.com/spf13/viper"
 )
 
 //DEFAULTS
 var (
-	defaultSchema        string   = "https://raw.githubusercontent.com/F5Networks/f5-appsvcs-extension/master/schema/latest/as3-schema.json"
-	defaultSchemaVersion string   = "3.20.0"
 	defaultUsername      string   = "admin"
+	defaultLoginProvider string   = "tmos"
 	defaultPort          string   = "8443"
-	requiredKeys         []string = []string{"gateway.name", "bigip.bigipurl", "bigip.bigippassword"}
+	defaultDebounceQuiet string   = "1s"
+	defaultDebounceMax   string   = "10s"
+	requiredKeys         []string = []string{"bigip.bigipurl", "bigip.bigippassword"}
+
+	// as3Name is the format AS3 accepts for tenants, applications and their objects
+	as3Name = regexp.MustCompile(`^[A-Za-z][0-9A-Za-z_]{0,47}$`)
 )
 
 type Config struct {
-	Gateway GatewayConfig
-	Bigip   as3.Params
-	Consul  consul.ConsulConfig
+	// Gateways is read from the [[gateway]] list, see loadGateways
+	Gateways []GatewayConfig
+	Bigip    as3.Params
+	Consul   consul.ConsulConfig
+	History  history.Config
+	Metrics  MetricsConfig
+}
+
+// MetricsConfig serves the metrics of bigip-tgw, such as the Consul changes
+// absorbed per configuration, in the expvar format on /debug/vars
+type MetricsConfig struct {
+	// Address is the listen address, empty disables the metrics endpoint
+	Address string
 }
 
 type GatewayConfig struct {
 	Name      string
 	Namespace string
+	// Tenant, Application and VirtualServer name the AS3 objects of the
+	// gateway, they default to names derived from Name
+	Tenant        string
+	Application   string
+	VirtualServer string
+	// HealthPolicy selects the instances sent to BIG-IP pools: passing, warning or all
+	HealthPolicy consul.HealthPolicy
+	// ServiceHealthPolicies overrides HealthPolicy for individual linked services
+	ServiceHealthPolicies map[string]consul.HealthPolicy
+	// TCPMonitorFallback monitors services without HTTP or TCP checks with a plain TCP monitor
+	TCPMonitorFallback bool
+	// UpstreamTLSDir is the local directory holding the files referenced by
+	// the CAFile, CertFile and KeyFile of the terminating gateway config entry
+	UpstreamTLSDir string
 }
 
 /*
@@ -71,24 +103,49 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
-	v.SetDefault("bigip.schema", defaultSchema)
-	v.SetDefault("bigip.schemaversion", defaultSchemaVersion)
 	v.SetDefault("bigip.BIGIPUsername", defaultUsername)
+	v.SetDefault("bigip.BIGIPLoginProvider", defaultLoginProvider)
+	v.SetDefault("consul.debouncequiet", defaultDebounceQuiet)
+	v.SetDefault("consul.debouncemaxwait", defaultDebounceMax)
+	v.SetDefault("history.size", history.DefaultSize)
 	//v.SetDefault("bigip.port", defaultPort)
 
 	v.BindEnv("consul.address")
 	v.BindEnv("consul.token")
+	v.BindEnv("consul.tokenfile")
 	v.BindEnv("consul.namespace")
+	v.BindEnv("consul.datacenter")
+	v.BindEnv("consul.scheme")
+	v.BindEnv("consul.tlsconfig.cafile")
+	v.BindEnv("consul.tlsconfig.capath")
+	v.BindEnv("consul.tlsconfig.certfile")
+	v.BindEnv("consul.tlsconfig.keyfile")
+	v.BindEnv("consul.tlsconfig.servername")
+	v.BindEnv("consul.tlsconfig.insecureskipverify")
 
 	v.BindEnv("bigip.BIGIPURL")
 	v.BindEnv("bigip.BIGIPUsername")
 	v.BindEnv("bigip.BIGIPPassword")
+	v.BindEnv("bigip.BIGIPLoginProvider")
+	v.BindEnv("bigip.AS3Validation")
+	v.BindEnv("bigip.SchemaLocalPath")
+	v.BindEnv("bigip.SchemaVersion")
+	v.BindEnv("bigip.EnableTLS")
+
+	v.BindEnv("history.dir")
+	v.BindEnv("history.keyfile")
+	v.BindEnv("metrics.address")
 
 	v.BindEnv("gateway.name")
+	v.BindEnv("gateway.tenant")
+	v.BindEnv("gateway.application")
+	v.BindEnv("gateway.virtualserver")
 	c := &Config{
-		Gateway: GatewayConfig{},
-		Bigip:   as3.Params{},
-		Consul:  consul.ConsulConfig{},
+		Gateways: []GatewayConfig{},
+		Bigip:    as3.Params{},
+		Consul:   consul.ConsulConfig{},
+		History:  history.Config{},
+		Metrics:  MetricsConfig{},
 	}
 	err = v.Unmarshal(c)
 	if err != nil {
@@ -99,5 +156,107 @@ func Load() (*Config, error) {
 			return c, fmt.Errorf("configuration element %s is not set", key)
 		}
 	}
+	switch c.Bigip.EnableTLS {
+	case "", "1.2", "1.3":
+	default:
+		return c, fmt.Errorf("configuration element bigip.enabletls has to be 1.2 or 1.3, not %q", c.Bigip.EnableTLS)
+	}
+	c.Gateways, err = loadGateways(v)
+	if err != nil {
+		return c, err
+	}
+	setGatewayNames(c.Gateways)
+	err = validateGateways(c.Gateways)
+	if err != nil {
+		return c, err
+	}
+	err = c.Consul.TLSConfig.Validate()
+	if err != nil {
+		return c, err
+	}
 	return c, err
 }
+
+// loadGateways reads the [[gateway]] list. A single [gateway] table,
+// optionally set through GATEWAY_ environment variables, is accepted as well.
+func loadGateways(v *viper.Viper) ([]GatewayConfig, error) {
+	var gateways []GatewayConfig
+	switch v.Get("gateway").(type) {
+	case []interface{}, []map[string]interface{}:
+		err := v.UnmarshalKey("gateway", &gateways)
+		return gateways, err
+	}
+
+	single := struct {
+		Gateway GatewayConfig
+	}{}
+	err := v.Unmarshal(&single)
+	if err != nil {
+		return gateways, err
+	}
+	return append(gateways, single.Gateway), nil
+}
+
+// setGatewayNames derives the AS3 names that are not configured from the gateway name
+func setGatewayNames(gateways []GatewayConfig) {
+	for i := range gateways {
+		gw := &gateways[i]
+		if gw.Tenant == "" {
+			gw.Tenant = gateway.TenantName(gw.Name)
+		}
+		if gw.Application == "" {
+			gw.Application = gateway.ApplicationName(gw.Name)
+		}
+		if gw.VirtualServer == "" {
+			gw.VirtualServer = gateway.VirtualServerName(gw.Name)
+		}
+	}
+}
+
+func validateGateways(gateways []GatewayConfig) error {
+	if len(gateways) == 0 {
+		return fmt.Errorf("configuration element gateway is not set")
+	}
+	seen := make(map[string]bool)
+	tenants := make(map[string]string)
+	for i, gw := range gateways {
+		if gw.Name == "" {
+			return fmt.Errorf("configuration element gateway.name is not set for gateway %d", i+1)
+		}
+		if seen[gw.Name] {
+			return fmt.Errorf("gateway %s is configured more than once", gw.Name)
+		}
+		seen[gw.Name] = true
+		for _, name := range []struct{ key, value, derived string }{
+			{"tenant", gw.Tenant, gateway.TenantName(gw.Name)},
+			{"application", gw.Application, gateway.ApplicationName(gw.Name)},
+			{"virtualserver", gw.VirtualServer, gateway.VirtualServerName(gw.Name)},
+		} {
+			if as3Name.MatchString(name.value) {
+				continue
+			}
+			if name.value == name.derived {
+				return fmt.Errorf("gateway %s: %s %q derived from the gateway name is not a valid AS3 name, shorten the gateway name or set %s", gw.Name, name.key, name.value, name.key)
+			}
+			return fmt.Errorf("gateway %s: %s %q is not a valid AS3 name, it has to start with a letter and contain at most 48 letters, digits or underscores", gw.Name, name.key, name.value)
+		}
+		if other, ok := tenants[gw.Tenant]; ok {
+			return fmt.Errorf("gateways %s and %s both use AS3 tenant %s", other, gw.Name, gw.Tenant)
+		}
+		tenants[gw.Tenant] = gw.Name
+		if err := gw.HealthPolicy.Validate(); err != nil {
+			return fmt.Errorf("gateway %s: %v", gw.Name, err)
+		}
+		for service, policy := range gw.ServiceHealthPolicies {
+			if err := policy.Validate(); err != nil {
+				return fmt.Errorf("gateway %s, service %s: %v", gw.Name, service, err)
+			}
+		}
+		if gw.UpstreamTLSDir != "" {
+			if info, err := os.Stat(gw.UpstreamTLSDir); err != nil || !info.IsDir() {
+				return fmt.Errorf("gateway %s: upstreamtlsdir %s is not a readable directory", gw.Name, gw.UpstreamTLSDir)
+			}
+		}
+	}
+	return nil
+}
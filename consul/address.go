@@ -0,0 +1,42 @@
+package consul
+
+import (
+	"net"
+	"regexp"
+	"strings"
+)
+
+// AddressType tells how the address of an instance has to be reached
+type AddressType string
+
+const (
+	AddressIPv4     AddressType = "ipv4"
+	AddressIPv6     AddressType = "ipv6"
+	AddressHostname AddressType = "hostname"
+)
+
+var hostnameLabel = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// classifyAddress returns the type of an instance address and the address in
+// the form expected by BIG-IP, it fails for anything that is neither an IP
+// address nor a valid hostname
+func classifyAddress(address string) (string, AddressType, bool) {
+	address = strings.TrimSpace(address)
+	if ip := net.ParseIP(strings.Trim(address, "[]")); ip != nil {
+		if ip.To4() != nil {
+			return ip.String(), AddressIPv4, true
+		}
+		return ip.String(), AddressIPv6, true
+	}
+
+	hostname := strings.ToLower(strings.TrimSuffix(address, "."))
+	if hostname == "" || len(hostname) > 253 {
+		return "", "", false
+	}
+	for _, label := range strings.Split(hostname, ".") {
+		if !hostnameLabel.MatchString(label) {
+			return "", "", false
+		}
+	}
+	return hostname, AddressHostname, true
+}
@@ -0,0 +1,127 @@
+package consul
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Check is a Consul HTTP or TCP check that probes the instance itself,
+// it can be reproduced by a BIG-IP monitor on the pool member
+type Check struct {
+	// Type is http, https or tcp
+	Type string
+	// Host is the Host header set on the check, if any
+	Host     string
+	Path     string
+	Method   string
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// serviceChecks returns the distinct checks of the instances of a service,
+// ordered by CheckID so that the monitors are numbered the same way whatever
+// order Consul returned them in
+func serviceChecks(entries []*api.ServiceEntry) []Check {
+	type instanceCheck struct {
+		entry *api.ServiceEntry
+		hc    *api.HealthCheck
+	}
+	var all []instanceCheck
+	for _, e := range entries {
+		for _, hc := range e.Checks {
+			all = append(all, instanceCheck{e, hc})
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		a, b := all[i].hc, all[j].hc
+		if a.CheckID != b.CheckID {
+			return a.CheckID < b.CheckID
+		}
+		if a.Node != b.Node {
+			return a.Node < b.Node
+		}
+		return a.ServiceID < b.ServiceID
+	})
+
+	var checks []Check
+	seen := make(map[Check]bool)
+	for _, ic := range all {
+		check, ok := newCheck(ic.entry, ic.hc)
+		if !ok || seen[check] {
+			continue
+		}
+		seen[check] = true
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+func newCheck(e *api.ServiceEntry, hc *api.HealthCheck) (Check, bool) {
+	if hc.ServiceID != e.Service.ID {
+		return Check{}, false
+	}
+	address := e.Service.Address
+	if address == "" {
+		address = e.Node.Address
+	}
+
+	def := hc.Definition
+	check := Check{
+		Interval: def.IntervalDuration,
+		Timeout:  def.TimeoutDuration,
+	}
+	switch {
+	case def.HTTP != "":
+		u, err := url.Parse(def.HTTP)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return Check{}, false
+		}
+		port := u.Port()
+		if port == "" {
+			port = "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+		}
+		if !probesInstance(u.Hostname(), port, address, e.Service.Port) {
+			log.Debugf("check %s of %s does not probe the instance, skipping", hc.CheckID, e.Service.ID)
+			return Check{}, false
+		}
+		check.Type = u.Scheme
+		if host, ok := def.Header["Host"]; ok && len(host) > 0 {
+			check.Host = host[0]
+		}
+		check.Path = u.RequestURI()
+		check.Method = def.Method
+		if check.Method == "" {
+			check.Method = "GET"
+		}
+	case def.TCP != "":
+		host, port, err := net.SplitHostPort(def.TCP)
+		if err != nil || !probesInstance(host, port, address, e.Service.Port) {
+			return Check{}, false
+		}
+		check.Type = "tcp"
+	default:
+		return Check{}, false
+	}
+	return check, true
+}
+
+// probesInstance reports whether a check target is the instance address and port
+func probesInstance(host string, port string, address string, servicePort int) bool {
+	if port != strconv.Itoa(servicePort) {
+		return false
+	}
+	host, _, ok := classifyAddress(host)
+	if !ok {
+		return false
+	}
+	address, _, ok = classifyAddress(address)
+	return ok && host == address
+}
@@ -2,7 +2,10 @@ package consul
 
 import (
 	"crypto/x509"
+	"sort"
 	"strings"
+
+	"github.com/hashicorp/consul/api"
 )
 
 type Config struct {
@@ -13,20 +16,46 @@ type Config struct {
 	CAsPool        *x509.CertPool
 	CAs            [][]byte
 	Services       []Service
+	// Events is the number of Consul changes absorbed by this configuration
+	Events int
+	// Indexes are the Consul indexes of the state the configuration was
+	// generated from, keyed by what was watched, e.g. health/<service>
+	Indexes map[string]uint64
 }
 
 type Service struct {
-	Name       string
+	Name string
+	// SNI is the server name sent by Connect proxies to reach the service
+	SNI        string
 	Instances  []*Instance
 	Intentions []string
-	ProxyTLS   *ProxyTLS
+	// ProxyTLS is set when the gateway originates TLS to the service
+	ProxyTLS *ProxyTLS
+	// Meta holds the bigip-tgw/ service metadata shared by the instances
+	Meta map[string]string
+	// Checks are the HTTP and TCP checks that probe the instances directly
+	Checks []Check
 	TLS
 }
 
+// MetaPrefix is the prefix of the Consul service metadata keys read by bigip-tgw
+const MetaPrefix = "bigip-tgw/"
+
 type Instance struct {
 	ID      string
 	Address string
-	Port    int
+	// AddressType is ipv4, ipv6 or hostname
+	AddressType AddressType
+	Port        int
+	// Status is the aggregated Consul health of the instance
+	Status string
+	// Weight is the Consul weight that applies to the instance's health
+	Weight int
+}
+
+// Maintenance reports whether the instance or its node is in maintenance mode
+func (i *Instance) Maintenance() bool {
+	return i.Status == api.HealthMaint
 }
 
 type TLS struct {
@@ -66,34 +95,103 @@ func (t *TLS) CAsString() string {
 func NewService(svc *service) Service {
 	downstream := Service{
 		Name: svc.name,
-		ProxyTLS: &ProxyTLS{
-			CAFile:   svc.gatewayService.CAFile,
-			CertFile: svc.gatewayService.CertFile,
-			KeyFile:  svc.gatewayService.KeyFile,
-			SNI:      svc.gatewayService.SNI,
-		},
 		TLS: TLS{
 			Cert: svc.leaf.Cert,
 			Key:  svc.leaf.Key,
 		},
 	}
+	gs := svc.gatewayService
+	if gs.CAFile != "" || gs.CertFile != "" || gs.KeyFile != "" || gs.SNI != "" {
+		downstream.ProxyTLS = &ProxyTLS{
+			CAFile:   gs.CAFile,
+			CertFile: gs.CertFile,
+			KeyFile:  gs.KeyFile,
+			SNI:      gs.SNI,
+		}
+	}
 	for _, i := range svc.instances {
+		status := i.Checks.AggregatedStatus()
+		if !svc.healthPolicy.Admits(status) {
+			continue
+		}
 		newInstance := &Instance{
-			ID:   i.Service.ID,
-			Port: i.Service.Port,
+			ID:     i.Service.ID,
+			Port:   i.Service.Port,
+			Status: status,
+			Weight: instanceWeight(i.Service.Weights, status),
+		}
+		address := i.Service.Address
+		if address == "" {
+			address = i.Node.Address
 		}
-		if i.Service.Address == "" {
-			newInstance.Address = i.Node.Address
-		} else {
-			newInstance.Address = i.Service.Address
+		var ok bool
+		newInstance.Address, newInstance.AddressType, ok = classifyAddress(address)
+		if !ok {
+			log.Warnf("instance %s of service %s has invalid address %q, skipping", i.Service.ID, svc.name, address)
+			continue
 		}
 		downstream.Instances = append(downstream.Instances, newInstance)
 	}
+	downstream.Meta = serviceMeta(svc)
+	downstream.Checks = serviceChecks(svc.instances)
 
+	allowed := make(map[string]bool)
 	for _, i := range svc.intentions {
-		if i.Action == "allow" {
+		if i.Action == "allow" && !allowed[i.SourceName] {
+			allowed[i.SourceName] = true
 			downstream.Intentions = append(downstream.Intentions, i.SourceName)
 		}
 	}
+	sort.Strings(downstream.Intentions)
 	return downstream
 }
+
+// sortEntries orders the instances of a service by id, Consul does not
+// guarantee the order and the declaration has to be stable
+func sortEntries(entries []*api.ServiceEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Service.ID != b.Service.ID {
+			return a.Service.ID < b.Service.ID
+		}
+		return a.Node.Node < b.Node.Node
+	})
+}
+
+// serviceMeta collects the bigip-tgw/ metadata of all instances of a service.
+// Instances are expected to agree, the first value seen wins otherwise.
+func serviceMeta(svc *service) map[string]string {
+	meta := make(map[string]string)
+	for _, i := range svc.instances {
+		for k, v := range i.Service.Meta {
+			if !strings.HasPrefix(k, MetaPrefix) {
+				continue
+			}
+			if current, ok := meta[k]; ok {
+				if current != v {
+					log.Warnf("instances of service %s disagree on metadata %s, using %q and ignoring %q", svc.name, k, current, v)
+				}
+				continue
+			}
+			meta[k] = v
+		}
+	}
+	return meta
+}
+
+// instanceWeight picks the Passing or Warning weight according to the health
+// of the instance. Instances registered without weights default to 1.
+func instanceWeight(weights api.AgentWeights, status string) int {
+	switch status {
+	case api.HealthPassing, api.HealthMaint:
+		if weights.Passing == 0 {
+			return 1
+		}
+		return weights.Passing
+	default:
+		if weights.Passing == 0 && weights.Warning == 0 {
+			return 1
+		}
+		return weights.Warning
+	}
+}
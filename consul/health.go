@@ -0,0 +1,67 @@
+package consul
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// HealthPolicy selects which instances of a service become pool members
+type HealthPolicy string
+
+const (
+	// HealthPolicyPassing keeps passing instances only
+	HealthPolicyPassing HealthPolicy = "passing"
+	// HealthPolicyWarning keeps passing and warning instances
+	HealthPolicyWarning HealthPolicy = "warning"
+	// HealthPolicyAll keeps every instance, including critical ones
+	HealthPolicyAll HealthPolicy = "all"
+
+	defaultHealthPolicy = HealthPolicyPassing
+)
+
+// Validate returns an error for unknown policies, an empty policy is valid
+func (p HealthPolicy) Validate() error {
+	switch p {
+	case "", HealthPolicyPassing, HealthPolicyWarning, HealthPolicyAll:
+		return nil
+	}
+	return fmt.Errorf("unknown health policy %q, expected one of %s, %s or %s",
+		p, HealthPolicyPassing, HealthPolicyWarning, HealthPolicyAll)
+}
+
+// Admits reports whether an instance with the given aggregated status is
+// kept. Instances in maintenance are always kept so that they can drain.
+func (p HealthPolicy) Admits(status string) bool {
+	switch status {
+	case api.HealthMaint, api.HealthPassing:
+		return true
+	case api.HealthWarning:
+		return p == HealthPolicyWarning || p == HealthPolicyAll
+	default:
+		return p == HealthPolicyAll
+	}
+}
+
+// SetHealthPolicy sets the gateway wide policy and the per service overrides.
+// It has to be called before Run.
+func (w *Watcher) SetHealthPolicy(policy HealthPolicy, services map[string]HealthPolicy) {
+	w.healthPolicy = policy
+	w.serviceHealthPolicy = make(map[string]HealthPolicy)
+	for name, p := range services {
+		w.serviceHealthPolicy[strings.ToLower(name)] = p
+	}
+}
+
+// healthPolicyFor matches service names case insensitively as the
+// configuration loader lowercases map keys
+func (w *Watcher) healthPolicyFor(service string) HealthPolicy {
+	if p, ok := w.serviceHealthPolicy[strings.ToLower(service)]; ok && p != "" {
+		return p
+	}
+	if w.healthPolicy != "" {
+		return w.healthPolicy
+	}
+	return defaultHealthPolicy
+}
@@ -0,0 +1,61 @@
+package consul
+
+import (
+	"encoding/json"
+	"expvar"
+	"strconv"
+	"sync"
+)
+
+// eventBuckets are the upper bounds of the buckets counting the Consul changes
+// absorbed by each configuration
+var eventBuckets = []int{1, 2, 5, 10, 20, 50, 100}
+
+// snapshotEvents holds the eventHistogram of every gateway, published on
+// /debug/vars as consul_snapshot_events
+var snapshotEvents = expvar.NewMap("consul_snapshot_events")
+
+// eventHistogram is a cumulative histogram of the Consul changes absorbed by
+// the configurations of a gateway, a large sum over few configurations means
+// debouncing collapsed bursts
+type eventHistogram struct {
+	lock    sync.Mutex
+	buckets []int64
+	count   int64
+	sum     int64
+}
+
+func newEventHistogram(gateway string) *eventHistogram {
+	h := &eventHistogram{buckets: make([]int64, len(eventBuckets))}
+	snapshotEvents.Set(gateway, h)
+	return h
+}
+
+func (h *eventHistogram) observe(events int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.count++
+	h.sum += int64(events)
+	for i, bound := range eventBuckets {
+		if events <= bound {
+			h.buckets[i]++
+		}
+	}
+}
+
+// String implements expvar.Var
+func (h *eventHistogram) String() string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	buckets := make(map[string]int64, len(eventBuckets)+1)
+	for i, bound := range eventBuckets {
+		buckets[strconv.Itoa(bound)] = h.buckets[i]
+	}
+	buckets["+Inf"] = h.count
+	b, _ := json.Marshal(struct {
+		Count   int64            `json:"count"`
+		Sum     int64            `json:"sum"`
+		Buckets map[string]int64 `json:"buckets"`
+	}{h.count, h.sum, buckets})
+	return string(b)
+}
@@ -0,0 +1,121 @@
+package consul
+
+import (
+	"context"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type errorClass int
+
+const (
+	// errorTransient covers network errors, 5xx and anything unclassified
+	errorTransient errorClass = iota
+	// errorACL is a 403 returned for a missing or insufficient token
+	errorACL
+	// errorNotFound is a 404, typically an unknown service or gateway
+	errorNotFound
+)
+
+// retryPolicy describes an exponential backoff, the wait doubles with every
+// consecutive failure from Initial up to Max
+type retryPolicy struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+var (
+	transientRetry = retryPolicy{Initial: 1 * time.Second, Max: 1 * time.Minute}
+	// ACL and not found errors do not go away on their own, do not hammer the servers
+	slowRetry = retryPolicy{Initial: 30 * time.Second, Max: 5 * time.Minute}
+
+	responseCode = regexp.MustCompile(`Unexpected response code: (\d{3})`)
+
+	jitterLock sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// backoff tracks the consecutive failures of a single watch loop
+type backoff struct {
+	failures uint
+}
+
+// next returns how long to wait before retrying after err
+func (b *backoff) next(err error) time.Duration {
+	policy := transientRetry
+	switch classifyError(err) {
+	case errorACL, errorNotFound:
+		policy = slowRetry
+	}
+
+	wait := policy.Max
+	if b.failures < 16 {
+		if d := policy.Initial << b.failures; d < policy.Max {
+			wait = d
+		}
+	}
+	b.failures++
+
+	// Spread retries between half and the full interval so that many
+	// gateways do not retry in lockstep after a Consul outage
+	jitterLock.Lock()
+	defer jitterLock.Unlock()
+	return wait/2 + time.Duration(jitterRand.Int63n(int64(wait/2)+1))
+}
+
+// reset is called after a successful query
+func (b *backoff) reset() {
+	b.failures = 0
+}
+
+func classifyError(err error) errorClass {
+	if err == nil {
+		return errorTransient
+	}
+	match := responseCode.FindStringSubmatch(err.Error())
+	if match == nil {
+		return errorTransient
+	}
+	code, _ := strconv.Atoi(match[1])
+	switch code {
+	case 403:
+		return errorACL
+	case 404:
+		return errorNotFound
+	}
+	return errorTransient
+}
+
+// waitOnError pauses a watch loop after a failed query until ctx is done.
+// A permission denied response re-reads the token file first and retries
+// at once if it rotated.
+func (w *Watcher) waitOnError(ctx context.Context, retry *backoff, err error) {
+	class := classifyError(err)
+	if class == errorACL && w.tokenFile != "" {
+		changed, rerr := w.reloadToken()
+		if rerr != nil {
+			log.Errorf("error reloading consul token: %s", rerr)
+		} else if changed {
+			log.Infof("consul token rotated from %s, retrying", w.tokenFile)
+			retry.reset()
+			return
+		}
+	}
+
+	wait := retry.next(err)
+	switch class {
+	case errorACL:
+		log.Errorf("consul denied access with the configured ACL token, check its policy for gateway %s, retrying in %s", w.name, wait)
+	case errorNotFound:
+		log.Warnf("consul returned not found for gateway %s, retrying in %s", w.name, wait)
+	default:
+		log.Debugf("retrying consul query in %s", wait)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(wait):
+	}
+}
@@ -0,0 +1,23 @@
+package consul
+
+import (
+	"strings"
+)
+
+// serviceSNI returns the SNI that Connect proxies send to reach a service
+// through the terminating gateway, as built by Consul:
+// <service>.<namespace>.<datacenter>.internal.<trust domain>
+func (w *Watcher) serviceSNI(d *service) string {
+	datacenter := w.gatewayDatacenter
+	if datacenter == "" {
+		datacenter = w.datacenter
+	}
+	if datacenter == "" || w.trustDomain == "" {
+		return ""
+	}
+	namespace := d.gatewayService.Service.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	return strings.ToLower(strings.Join([]string{d.name, namespace, datacenter, "internal", w.trustDomain}, "."))
+}
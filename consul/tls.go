@@ -0,0 +1,95 @@
+package consul
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// TLSConfig holds the options used to reach the Consul HTTP API over TLS
+type TLSConfig struct {
+	// CAFile is the path to a PEM encoded CA bundle used to verify the Consul agent
+	CAFile string
+
+	// CAPath is the path to a directory of PEM encoded CA certificates
+	CAPath string
+
+	// CertFile is the path to the client certificate presented to Consul
+	// when verify_incoming is enabled. If set, KeyFile must be set as well.
+	CertFile string
+
+	// KeyFile is the path to the private key for CertFile
+	KeyFile string
+
+	// ServerName is the SNI and hostname used to verify the Consul agent
+	// certificate. If not provided, the host part of Address is used.
+	ServerName string
+
+	// InsecureSkipVerify disables verification of the Consul agent certificate
+	InsecureSkipVerify bool
+}
+
+// Validate checks that every file referenced by the TLS configuration
+// exists and holds usable PEM data
+func (t *TLSConfig) Validate() error {
+	if t.CAFile != "" {
+		data, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return fmt.Errorf("unable to read consul CA file %s: %v", t.CAFile, err)
+		}
+		if ok := x509.NewCertPool().AppendCertsFromPEM(data); !ok {
+			return fmt.Errorf("consul CA file %s does not contain a valid PEM certificate", t.CAFile)
+		}
+	}
+
+	if t.CAPath != "" {
+		info, err := os.Stat(t.CAPath)
+		if err != nil {
+			return fmt.Errorf("unable to read consul CA path %s: %v", t.CAPath, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("consul CA path %s is not a directory", t.CAPath)
+		}
+	}
+
+	if t.CertFile == "" && t.KeyFile == "" {
+		return nil
+	}
+	if t.CertFile == "" {
+		return fmt.Errorf("consul client key file %s is set but no client cert file is configured", t.KeyFile)
+	}
+	if t.KeyFile == "" {
+		return fmt.Errorf("consul client cert file %s is set but no client key file is configured", t.CertFile)
+	}
+	if _, err := os.Stat(t.CertFile); err != nil {
+		return fmt.Errorf("unable to read consul client cert file %s: %v", t.CertFile, err)
+	}
+	if _, err := os.Stat(t.KeyFile); err != nil {
+		return fmt.Errorf("unable to read consul client key file %s: %v", t.KeyFile, err)
+	}
+	if _, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile); err != nil {
+		return fmt.Errorf("invalid consul client cert %s or key %s: %v", t.CertFile, t.KeyFile, err)
+	}
+	return nil
+}
+
+// Enabled reports whether any TLS option has been configured
+func (t *TLSConfig) Enabled() bool {
+	return t.CAFile != "" || t.CAPath != "" || t.CertFile != "" || t.KeyFile != "" ||
+		t.ServerName != "" || t.InsecureSkipVerify
+}
+
+func (t *TLSConfig) apiConfig() api.TLSConfig {
+	return api.TLSConfig{
+		Address:            t.ServerName,
+		CAFile:             t.CAFile,
+		CAPath:             t.CAPath,
+		CertFile:           t.CertFile,
+		KeyFile:            t.KeyFile,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+}
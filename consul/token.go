@@ -0,0 +1,62 @@
+package consul
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const (
+	tokenFileCheckInterval = 10 * time.Second
+)
+
+func (w *Watcher) getToken() string {
+	w.tokenLock.RLock()
+	defer w.tokenLock.RUnlock()
+	return w.token
+}
+
+// reloadToken reads the token file and swaps in its content,
+// it reports whether the token differs from the one in use
+func (w *Watcher) reloadToken() (bool, error) {
+	data, err := ioutil.ReadFile(w.tokenFile)
+	if err != nil {
+		return false, fmt.Errorf("unable to read consul token file %s: %v", w.tokenFile, err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return false, fmt.Errorf("consul token file %s is empty", w.tokenFile)
+	}
+
+	w.tokenLock.Lock()
+	defer w.tokenLock.Unlock()
+	if token == w.token {
+		return false, nil
+	}
+	w.token = token
+	return true, nil
+}
+
+// watchTokenFile polls the token file so that rotated tokens are picked up
+// by the blocking queries on their next iteration
+func (w *Watcher) watchTokenFile() {
+	log.Debugf("watching token file %s", w.tokenFile)
+	ticker := time.NewTicker(tokenFileCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := w.reloadToken()
+		if err != nil {
+			log.Errorf("error reloading consul token: %s", err)
+			continue
+		}
+		if changed {
+			log.Infof("consul token rotated from %s", w.tokenFile)
+		}
+	}
+}
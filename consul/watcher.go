@@ -1,8 +1,13 @@
 package consul
 
 import (
+	"context"
 	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	slog "github.com/go-eden/slf4go"
@@ -10,10 +15,6 @@ import (
 	"github.com/hashicorp/consul/api"
 )
 
-const (
-	errorWaitTime = 5 * time.Second
-)
-
 var log = slog.NewLogger("consul-watcher")
 
 type ConsulConfig struct {
@@ -24,6 +25,8 @@ type ConsulConfig struct {
 	Scheme string
 
 	// Datacenter to use. If not provided, the default agent datacenter is used.
+	// A datacenter other than the agent's own is queried through WAN federation,
+	// the agent must then be able to issue leaf certs for that datacenter.
 	Datacenter string
 
 	// Transport is the Transport to use for the http client.
@@ -45,14 +48,24 @@ type ConsulConfig struct {
 	Token string
 
 	// TokenFile is a file containing the current token to use for this client.
-	// If provided it is read once at startup and never again.
-	//TokenFile string
+	// If provided it takes precedence over Token and is watched for changes,
+	// a rotated token is used by every watch from its next query onwards.
+	TokenFile string
 
 	// Namespace is the name of the namespace to send along for the request
 	// when no other Namespace ispresent in the QueryOptions
 	Namespace string
 
-	//TLSConfig TLSConfig
+	// TLSConfig is used to connect to the Consul HTTP API over HTTPS
+	TLSConfig TLSConfig
+
+	// DebounceQuiet is how long no change has to be seen before a new
+	// configuration is generated. Zero generates one for every change.
+	DebounceQuiet time.Duration
+
+	// DebounceMaxWait caps how long a continuous stream of changes can
+	// delay a new configuration. Zero means no cap.
+	DebounceMaxWait time.Duration
 }
 
 type service struct {
@@ -61,9 +74,15 @@ type service struct {
 	intentions     []*api.Intention
 	gatewayService *api.GatewayService
 	leaf           *certLeaf
-
-	ready sync.WaitGroup
-	done  bool
+	healthPolicy   HealthPolicy
+	// Consul indexes of the instances, intentions and leaf cert
+	instancesIndex  uint64
+	intentionsIndex uint64
+	leafIndex       uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	ready  sync.WaitGroup
 }
 
 type certLeaf struct {
@@ -83,28 +102,63 @@ type Watcher struct {
 	address   string
 	port      int
 	consul    *api.Client
-	token     string
 	C         chan Config
 
+	datacenter      string
+	agentDatacenter string
+	// gatewayDatacenter is the datacenter the gateway is registered in
+	gatewayDatacenter string
+
+	healthPolicy        HealthPolicy
+	serviceHealthPolicy map[string]HealthPolicy
+
+	tokenLock sync.RWMutex
+	token     string
+	tokenFile string
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped chan struct{}
+	// failed stops Run with an error the watcher cannot recover from
+	failed chan error
+
 	lock  sync.Mutex
 	ready sync.WaitGroup
 
-	services   map[string]*service
-	certCAs    [][]byte
-	certCAPool *x509.CertPool
-	leaf       *certLeaf
+	services    map[string]*service
+	certCAs     [][]byte
+	certCAPool  *x509.CertPool
+	trustDomain string
+	leaf        *certLeaf
+
+	// Consul indexes of the linked services, the gateway and the CA roots
+	gatewayServicesIndex uint64
+	gatewayIndex         uint64
+	caIndex              uint64
 
 	update chan struct{}
+	// events counts the changes absorbed by the next configuration
+	events int32
+	// eventHistogram is the metric of the changes absorbed per configuration
+	eventHistogram *eventHistogram
+
+	debounceQuiet   time.Duration
+	debounceMaxWait time.Duration
 }
 
 //New Watcher
 func New() *Watcher {
 
 	log.Info("creating new Consul watcher")
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Watcher{
 		C:        make(chan Config),
 		services: make(map[string]*service),
 		update:   make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+		stopped:  make(chan struct{}),
+		failed:   make(chan error, 1),
 	}
 }
 
@@ -117,34 +171,191 @@ func (w *Watcher) Init(c ConsulConfig, gatewayName string, namespace string) err
 	w.settings = *api.DefaultConfig()
 	w.settings.Address = c.Address
 	w.settings.Scheme = c.Scheme
-	w.settings.Token = c.Token
+	w.token = c.Token
+	if c.TokenFile != "" {
+		w.tokenFile = c.TokenFile
+		_, err = w.reloadToken()
+		if err != nil {
+			return err
+		}
+	}
+	w.settings.Token = w.token
 	w.settings.Namespace = c.Namespace
+	w.settings.Datacenter = c.Datacenter
+	w.datacenter = c.Datacenter
+	w.debounceQuiet = c.DebounceQuiet
+	w.debounceMaxWait = c.DebounceMaxWait
+	if namespace != "" {
+		w.eventHistogram = newEventHistogram(namespace + "/" + gatewayName)
+	} else {
+		w.eventHistogram = newEventHistogram(gatewayName)
+	}
+	if c.TLSConfig.Enabled() {
+		if w.settings.Scheme == "" {
+			w.settings.Scheme = "https"
+		}
+		w.settings.TLSConfig = c.TLSConfig.apiConfig()
+	}
 	w.consul, err = api.NewClient(&w.settings)
 	if err != nil {
 		return err
 	}
+	if w.datacenter != "" {
+		w.agentDatacenter, err = w.localDatacenter()
+		if err != nil {
+			log.Warnf("unable to determine the datacenter of the consul agent: %s", err)
+		} else if w.isRemote() {
+			log.Infof("watching gateway %s in remote datacenter %s through agent in datacenter %s", gatewayName, w.datacenter, w.agentDatacenter)
+			if err = w.checkRemoteLeaf(); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
+// checkRemoteLeaf makes sure the agent issues leaf certs for the remote
+// datacenter of the gateway, leaf certs are served by the local agent only
+func (w *Watcher) checkRemoteLeaf() error {
+	opts := &api.QueryOptions{
+		Token:      w.getToken(),
+		Datacenter: w.datacenter,
+	}
+	cert, _, err := w.consul.Agent().ConnectCALeaf(w.name, opts)
+	if err != nil {
+		return fmt.Errorf("consul agent in datacenter %s cannot serve leaf certs for remote datacenter %s: %s", w.agentDatacenter, w.datacenter, err)
+	}
+	return w.checkLeafDatacenter(cert)
+}
+
+func (w *Watcher) localDatacenter() (string, error) {
+	self, err := w.consul.Agent().Self()
+	if err != nil {
+		return "", err
+	}
+	dc, ok := self["Config"]["Datacenter"].(string)
+	if !ok {
+		return "", fmt.Errorf("agent did not report its datacenter")
+	}
+	return dc, nil
+}
+
+// isRemote reports whether the gateway lives in another datacenter than the agent
+func (w *Watcher) isRemote() bool {
+	return w.datacenter != "" && w.agentDatacenter != "" && w.datacenter != w.agentDatacenter
+}
+
+// checkLeafDatacenter makes sure a leaf cert served by the local agent was
+// issued for the datacenter of the gateway
+func (w *Watcher) checkLeafDatacenter(cert *api.LeafCert) error {
+	if w.datacenter == "" || strings.Contains(cert.ServiceURI, "/dc/"+w.datacenter+"/") {
+		return nil
+	}
+	return fmt.Errorf("agent in datacenter %s issued leaf cert %s for service %s, which is not valid in datacenter %s",
+		w.agentDatacenter, cert.ServiceURI, cert.Service, w.datacenter)
+}
+
 //Run Watcher
 func (w *Watcher) Run() error {
 
 	//Debug
 	log.Debugf("running watcher for gateway: %s\n", w.name)
+	defer close(w.stopped)
 
 	w.ready.Add(3)
 
-	go w.watchService(w.name, true, "terminating-gateway")
+	go w.watchService(w.ctx, nil, true)
 	go w.watchGateway()
 	go w.watchCA()
+	if w.tokenFile != "" {
+		go w.watchTokenFile()
+	}
+
+	ready := make(chan struct{})
+	go func() {
+		w.ready.Wait()
+		close(ready)
+	}()
+	select {
+	case <-ready:
+	case err := <-w.failed:
+		w.cancel()
+		return err
+	case <-w.ctx.Done():
+		log.Infof("stopped watcher for gateway: %s", w.name)
+		return nil
+	}
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			log.Infof("stopped watcher for gateway: %s", w.name)
+			return nil
+		case err := <-w.failed:
+			w.cancel()
+			return err
+		case <-w.update:
+		}
+		if !w.debounce() {
+			log.Infof("stopped watcher for gateway: %s", w.name)
+			return nil
+		}
+		cfg := w.genCfg()
+		log.Infof("generated configuration for gateway %s from %d consul changes", w.name, cfg.Events)
+		w.eventHistogram.observe(cfg.Events)
+		select {
+		case w.C <- cfg:
+		case <-w.ctx.Done():
+		}
+	}
+}
 
-	w.ready.Wait()
+// debounce waits for the quiet period to pass without further changes so
+// that bursts, such as every leaf cert renewing after a CA rotation, end up
+// in a single configuration. It never waits longer than the max wait.
+// It returns false when the watcher is stopped.
+func (w *Watcher) debounce() bool {
+	if w.debounceQuiet <= 0 {
+		return true
+	}
+	quiet := time.NewTimer(w.debounceQuiet)
+	defer quiet.Stop()
+	var deadline <-chan time.Time
+	if w.debounceMaxWait > 0 {
+		maxWait := time.NewTimer(w.debounceMaxWait)
+		defer maxWait.Stop()
+		deadline = maxWait.C
+	}
 
-	for range w.update {
-		w.C <- w.genCfg()
+	for {
+		select {
+		case <-w.ctx.Done():
+			return false
+		case <-deadline:
+			return true
+		case <-quiet.C:
+			return true
+		case <-w.update:
+			if !quiet.Stop() {
+				<-quiet.C
+			}
+			quiet.Reset(w.debounceQuiet)
+		}
 	}
+}
 
-	return nil
+// Stop cancels every blocking query of the watcher and waits for Run to return
+func (w *Watcher) Stop() {
+	w.cancel()
+	<-w.stopped
+}
+
+// fail stops Run with err
+func (w *Watcher) fail(err error) {
+	select {
+	case w.failed <- err:
+	default:
+	}
 }
 
 //Reload Configuration
@@ -152,23 +363,33 @@ func (w *Watcher) Reload() {
 	w.C <- w.genCfg()
 }
 
-func (w *Watcher) watchLeaf(service string, first bool) {
+func (w *Watcher) watchLeaf(d *service, first bool) {
+	service := d.name
 	log.Debugf("watching leaf cert for %s", service)
 	dFirst := true
+	defer func() {
+		w.abandonReady(d, dFirst, first)
+	}()
 	var lastIndex uint64
+	var retry backoff
 	for {
-		if w.services[service] == nil {
-			return
-		} else if w.services[service].done {
+		opts := &api.QueryOptions{
+			WaitTime:   10 * time.Minute,
+			WaitIndex:  lastIndex,
+			Token:      w.getToken(),
+			Datacenter: w.datacenter,
+		}
+		cert, meta, err := w.consul.Agent().ConnectCALeaf(service, opts.WithContext(d.ctx))
+		if d.ctx.Err() != nil {
 			return
 		}
-		cert, meta, err := w.consul.Agent().ConnectCALeaf(service, &api.QueryOptions{
-			WaitTime:  10 * time.Minute,
-			WaitIndex: lastIndex,
-		})
 		if err != nil {
-			log.Errorf("consul error fetching leaf cert for service %s: %s", service, err)
-			time.Sleep(errorWaitTime)
+			if w.isRemote() {
+				log.Errorf("consul agent in datacenter %s cannot serve leaf cert for service %s in remote datacenter %s: %s", w.agentDatacenter, service, w.datacenter, err)
+			} else {
+				log.Errorf("consul error fetching leaf cert for service %s: %s", service, err)
+			}
+			w.waitOnError(d.ctx, &retry, err)
 			if meta != nil {
 				if meta.LastIndex < lastIndex || meta.LastIndex < 1 {
 					lastIndex = 0
@@ -177,20 +398,27 @@ func (w *Watcher) watchLeaf(service string, first bool) {
 			continue
 		}
 
+		if err = w.checkLeafDatacenter(cert); err != nil {
+			w.fail(fmt.Errorf("unable to use leaf cert for service %s: %s", service, err))
+			return
+		}
+
+		retry.reset()
 		changed := lastIndex != meta.LastIndex
 		lastIndex = meta.LastIndex
 
 		if changed {
 			log.Infof("leaf cert for service %s changed, serial: %s, valid before: %s, valid after: %s", service, cert.SerialNumber, cert.ValidBefore, cert.ValidAfter)
 			w.lock.Lock()
-			if w.services[service].leaf == nil {
-				w.services[service].leaf = &certLeaf{}
+			if d.leaf == nil {
+				d.leaf = &certLeaf{}
 			}
-			w.services[service].leaf.Cert = []byte(cert.CertPEM)
-			w.services[service].leaf.Key = []byte(cert.PrivateKeyPEM)
+			d.leaf.Cert = []byte(cert.CertPEM)
+			d.leaf.Key = []byte(cert.PrivateKeyPEM)
+			d.leafIndex = meta.LastIndex
 			w.lock.Unlock()
 			if dFirst {
-				w.services[service].ready.Done()
+				d.ready.Done()
 				dFirst = false
 			} else {
 				w.notifyChanged()
@@ -205,25 +433,31 @@ func (w *Watcher) watchLeaf(service string, first bool) {
 	}
 }
 
-func (w *Watcher) watchIntention(service string, first bool) {
+func (w *Watcher) watchIntention(d *service, first bool) {
+	service := d.name
 	log.Debugf("watching intentions for %s", service)
 	dFirst := true
+	defer func() {
+		w.abandonReady(d, dFirst, first)
+	}()
 	var lastIndex uint64
+	var retry backoff
 
 	for {
-		if w.services[service] == nil {
-			return
-		} else if w.services[service].done {
+		opts := &api.QueryOptions{
+			WaitTime:   10 * time.Minute,
+			WaitIndex:  lastIndex,
+			Filter:     "DestinationName==" + service,
+			Token:      w.getToken(),
+			Datacenter: w.datacenter,
+		}
+		intentionList, meta, err := w.consul.Connect().Intentions(opts.WithContext(d.ctx))
+		if d.ctx.Err() != nil {
 			return
 		}
-		intentionList, meta, err := w.consul.Connect().Intentions(&api.QueryOptions{
-			WaitTime:  10 * time.Minute,
-			WaitIndex: lastIndex,
-			Filter:    "DestinationName==" + service,
-		})
 		if err != nil {
 			log.Errorf("consul error fetching intentions for service %s: %s", service, err)
-			time.Sleep(errorWaitTime)
+			w.waitOnError(d.ctx, &retry, err)
 			if meta != nil {
 				if meta.LastIndex < lastIndex || meta.LastIndex < 1 {
 					lastIndex = 0
@@ -232,16 +466,18 @@ func (w *Watcher) watchIntention(service string, first bool) {
 			continue
 		}
 
+		retry.reset()
 		changed := lastIndex != meta.LastIndex
 		lastIndex = meta.LastIndex
 
 		if changed {
 			log.Infof("intentions for service %s changed", service)
 			w.lock.Lock()
-			w.services[service].intentions = intentionList
+			d.intentions = intentionList
+			d.intentionsIndex = meta.LastIndex
 			w.lock.Unlock()
 			if dFirst {
-				w.services[service].ready.Done()
+				d.ready.Done()
 				dFirst = false
 			} else {
 				w.notifyChanged()
@@ -256,17 +492,40 @@ func (w *Watcher) watchIntention(service string, first bool) {
 	}
 }
 
+// abandonReady releases the readiness a cancelled watch still holds so
+// that watchService and Run do not wait for it forever
+func (w *Watcher) abandonReady(d *service, dFirst bool, first bool) {
+	if dFirst {
+		d.ready.Done()
+	}
+	if first {
+		w.ready.Done()
+	}
+}
+
 func (w *Watcher) watchGateway() {
 	var lastIndex uint64
+	var retry backoff
 	first := true
+	defer func() {
+		if first {
+			w.ready.Done()
+		}
+	}()
 	for {
-		gwServices, meta, err := w.consul.Catalog().GatewayServices(w.name, &api.QueryOptions{
-			WaitTime:  10 * time.Minute,
-			WaitIndex: lastIndex,
-		})
+		opts := &api.QueryOptions{
+			WaitTime:   10 * time.Minute,
+			WaitIndex:  lastIndex,
+			Token:      w.getToken(),
+			Datacenter: w.datacenter,
+		}
+		gwServices, meta, err := w.consul.Catalog().GatewayServices(w.name, opts.WithContext(w.ctx))
+		if w.ctx.Err() != nil {
+			return
+		}
 		if err != nil {
 			log.Errorf("error fetching linked services for gateway %s: %s", w.name, err)
-			time.Sleep(errorWaitTime)
+			w.waitOnError(w.ctx, &retry, err)
 			if meta != nil {
 				if meta.LastIndex < lastIndex || meta.LastIndex < 1 {
 					lastIndex = 0
@@ -275,6 +534,7 @@ func (w *Watcher) watchGateway() {
 			continue
 		}
 
+		retry.reset()
 		changed := lastIndex != meta.LastIndex
 		lastIndex = meta.LastIndex
 
@@ -284,6 +544,9 @@ func (w *Watcher) watchGateway() {
 				log.Infof("no linked services defined for gateway %s", w.name)
 				continue
 			}
+			w.lock.Lock()
+			w.gatewayServicesIndex = meta.LastIndex
+			w.lock.Unlock()
 			w.handleProxyChange(first, &gwServices)
 		}
 		if first {
@@ -294,33 +557,39 @@ func (w *Watcher) watchGateway() {
 	}
 }
 
-func (w *Watcher) watchService(service string, first bool, kind string) {
+// watchService watches the instances of a linked service, or the
+// terminating gateway itself when d is nil
+func (w *Watcher) watchService(ctx context.Context, d *service, first bool) {
+	service := w.name
+	nSpace := w.namespace
+	if d != nil {
+		service = d.name
+		nSpace = d.gatewayService.Service.Namespace
+	}
 	log.Infof("watching downstream: %s", service)
 	dFirst := true
+	defer func() {
+		if first {
+			w.ready.Done()
+		}
+	}()
 	var lastIndex uint64
-	var nSpace string
+	var retry backoff
 	for {
-		if kind != "terminating-gateway" {
-			if &w.services[service].gatewayService.Service.Namespace != nil {
-				nSpace = w.services[service].gatewayService.Service.Namespace
-			}
-			if w.services[service] == nil {
-				return
-			} else if w.services[service].done {
-				return
-			}
-		} else {
-			nSpace = w.namespace
+		opts := &api.QueryOptions{
+			WaitIndex:  lastIndex,
+			WaitTime:   10 * time.Minute,
+			Namespace:  nSpace,
+			Token:      w.getToken(),
+			Datacenter: w.datacenter,
+		}
+		srv, meta, err := w.consul.Health().Service(service, "", false, opts.WithContext(ctx))
+		if ctx.Err() != nil {
+			return
 		}
-
-		srv, meta, err := w.consul.Health().Service(service, "", false, &api.QueryOptions{
-			WaitIndex: lastIndex,
-			WaitTime:  10 * time.Minute,
-			Namespace: nSpace,
-		})
 		if err != nil {
 			log.Errorf("error fetching service %s definition: %s", service, err)
-			time.Sleep(errorWaitTime)
+			w.waitOnError(ctx, &retry, err)
 			if meta != nil {
 				if meta.LastIndex < lastIndex || meta.LastIndex < 1 {
 					lastIndex = 0
@@ -329,6 +598,7 @@ func (w *Watcher) watchService(service string, first bool, kind string) {
 			continue
 		}
 
+		retry.reset()
 		changed := lastIndex != meta.LastIndex
 		lastIndex = meta.LastIndex
 
@@ -337,24 +607,30 @@ func (w *Watcher) watchService(service string, first bool, kind string) {
 			if len(srv) == 0 {
 				log.Infof("no service definition found for: %s", service)
 				continue
-			} else if len(srv) > 1 && kind == "terminating-gateway" {
+			} else if len(srv) > 1 && d == nil {
 				log.Errorf("too many service definitions found for: %s", service)
 				continue
 			}
 
 			w.lock.Lock()
-			if kind == "terminating-gateway" {
+			if d == nil {
 				w.id = srv[0].Service.ID
-				w.name = srv[0].Service.Service
 				w.address = srv[0].Service.Address
+				w.gatewayDatacenter = srv[0].Node.Datacenter
 				w.port = srv[0].Service.Port
+				w.gatewayIndex = meta.LastIndex
 			} else {
-				w.services[service].instances = srv
+				sortEntries(srv)
+				d.instances = srv
+				d.instancesIndex = meta.LastIndex
 			}
 			w.lock.Unlock()
-			if dFirst && kind != "terminating-gateway" {
-				w.services[service].ready.Wait()
+			if dFirst && d != nil {
+				d.ready.Wait()
 				dFirst = false
+				if ctx.Err() != nil {
+					return
+				}
 			}
 			w.notifyChanged()
 		}
@@ -384,11 +660,17 @@ func (w *Watcher) handleProxyChange(first bool, gwServices *[]*api.GatewayServic
 		}
 	}
 
+	var remove []string
+	w.lock.Lock()
 	for name := range w.services {
 		if !keep[name] {
-			w.removeService(name)
+			remove = append(remove, name)
 		}
 	}
+	w.lock.Unlock()
+	for _, name := range remove {
+		w.removeService(name)
+	}
 }
 
 func (w *Watcher) startService(down *api.GatewayService, first bool) {
@@ -396,25 +678,30 @@ func (w *Watcher) startService(down *api.GatewayService, first bool) {
 	d := &service{
 		name:           down.Service.Name,
 		gatewayService: down,
+		healthPolicy:   w.healthPolicyFor(down.Service.Name),
 	}
+	d.ctx, d.cancel = context.WithCancel(w.ctx)
 
 	w.lock.Lock()
 	w.services[down.Service.Name] = d
 	w.lock.Unlock()
 
 	d.ready.Add(2)
-	go w.watchService(d.name, first, "")
-	go w.watchLeaf(d.name, first)
-	go w.watchIntention(d.name, first)
+	go w.watchService(d.ctx, d, first)
+	go w.watchLeaf(d, first)
+	go w.watchIntention(d, first)
 }
 
 func (w *Watcher) removeService(name string) {
 	log.Infof("removing downstream for service %s", name)
 
 	w.lock.Lock()
-	w.services[name].done = true
+	d, ok := w.services[name]
 	delete(w.services, name)
 	w.lock.Unlock()
+	if ok {
+		d.cancel()
+	}
 	w.notifyChanged()
 }
 
@@ -422,15 +709,27 @@ func (w *Watcher) watchCA() {
 	log.Debugf("watching ca certs")
 
 	first := true
+	defer func() {
+		if first {
+			w.ready.Done()
+		}
+	}()
 	var lastIndex uint64
+	var retry backoff
 	for {
-		caList, meta, err := w.consul.Agent().ConnectCARoots(&api.QueryOptions{
-			WaitIndex: lastIndex,
-			WaitTime:  10 * time.Minute,
-		})
+		opts := &api.QueryOptions{
+			WaitIndex:  lastIndex,
+			WaitTime:   10 * time.Minute,
+			Token:      w.getToken(),
+			Datacenter: w.datacenter,
+		}
+		caList, meta, err := w.consul.Agent().ConnectCARoots(opts.WithContext(w.ctx))
+		if w.ctx.Err() != nil {
+			return
+		}
 		if err != nil {
 			log.Errorf("error fetching cas: %s", err)
-			time.Sleep(errorWaitTime)
+			w.waitOnError(w.ctx, &retry, err)
 			if meta != nil {
 				if meta.LastIndex < lastIndex || meta.LastIndex < 1 {
 					lastIndex = 0
@@ -439,6 +738,7 @@ func (w *Watcher) watchCA() {
 			continue
 		}
 
+		retry.reset()
 		changed := lastIndex != meta.LastIndex
 		lastIndex = meta.LastIndex
 
@@ -447,6 +747,12 @@ func (w *Watcher) watchCA() {
 			w.lock.Lock()
 			w.certCAs = w.certCAs[:0]
 			w.certCAPool = x509.NewCertPool()
+			w.trustDomain = caList.TrustDomain
+			w.caIndex = meta.LastIndex
+			// roots are ordered by id so that the bundle is rendered identically
+			sort.Slice(caList.Roots, func(i, j int) bool {
+				return caList.Roots[i].ID < caList.Roots[j].ID
+			})
 			for _, ca := range caList.Roots {
 				w.certCAs = append(w.certCAs, []byte(ca.RootCertPEM))
 				ok := w.certCAPool.AppendCertsFromPEM([]byte(ca.RootCertPEM))
@@ -474,28 +780,56 @@ func (w *Watcher) genCfg() Config {
 		log.Debugf("done generating configuration")
 	}()
 
+	events := int(atomic.SwapInt32(&w.events, 0))
 	if len(w.services) == 0 {
-		return Config{}
+		return Config{Events: events}
 	}
 
 	watcherConfig := Config{
+		Events:         events,
 		GatewayName:    w.name,
 		GatewayID:      w.id,
 		GatewayAddress: w.address,
 		GatewayPort:    w.port,
 		CAsPool:        w.certCAPool,
 		CAs:            w.certCAs,
+		Indexes: map[string]uint64{
+			"gateway-services": w.gatewayServicesIndex,
+			"gateway":          w.gatewayIndex,
+			"ca-roots":         w.caIndex,
+		},
 	}
 
-	for _, down := range w.services {
+	names := make([]string, 0, len(w.services))
+	for name := range w.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		down := w.services[name]
+		// services linked after startup are left out until their leaf cert arrives
+		if down.leaf == nil {
+			log.Debugf("service %s is not ready yet", down.name)
+			continue
+		}
 		downstream := NewService(down)
 		downstream.TLS.CAs = w.certCAs
+		downstream.SNI = w.serviceSNI(down)
+		if downstream.SNI == "" {
+			log.Warnf("unable to determine the SNI of service %s, datacenter: %q, trust domain: %q", down.name, w.gatewayDatacenter, w.trustDomain)
+			continue
+		}
 		watcherConfig.Services = append(watcherConfig.Services, downstream)
+		watcherConfig.Indexes["health/"+name] = down.instancesIndex
+		watcherConfig.Indexes["intentions/"+name] = down.intentionsIndex
+		watcherConfig.Indexes["leaf/"+name] = down.leafIndex
 	}
 	return watcherConfig
 }
 
 func (w *Watcher) notifyChanged() {
+	atomic.AddInt32(&w.events, 1)
 	select {
 	case w.update <- struct{}{}:
 	default:
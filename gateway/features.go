@@ -0,0 +1,53 @@
+package gateway
+
+import (
+	"github.com/f5devcentral/bigip-tgw/as3"
+)
+
+// feature is an AS3 property that is only rendered when the declared
+// schemaVersion supports it
+type feature string
+
+const (
+	featureTLS13 feature = "TLS 1.3 on TLS_Server"
+)
+
+// featureVersions holds the AS3 release that introduced each feature. Only
+// properties newer than AS3 3.20, the oldest schemaVersion ever declared,
+// need an entry.
+var featureVersions = map[feature]as3.Version{
+	featureTLS13: {Major: 3, Minor: 23},
+}
+
+const defaultTLS13CipherGroup = "/Common/f5-default"
+
+// supports reports whether the declared schemaVersion accepts a feature.
+// Unsupported features are logged once and the caller falls back.
+func (f5 *Bigip) supports(f feature) bool {
+	if f5.Options.SchemaVersion.AtLeast(featureVersions[f]) {
+		return true
+	}
+	if !f5.unsupported[f] {
+		log.Warnf("[WARN] %s requires AS3 %v, schemaVersion %v is declared, falling back",
+			f, featureVersions[f], f5.Options.SchemaVersion)
+		if f5.unsupported == nil {
+			f5.unsupported = make(map[feature]bool)
+		}
+		f5.unsupported[f] = true
+	}
+	return false
+}
+
+// applyTLSVersion enables TLS 1.3 toward the Connect proxies when it is
+// configured and supported, TLS 1.2 with the configured ciphers otherwise
+func (f5 *Bigip) applyTLSVersion(server *as3.ServerTLS) {
+	if f5.Config.EnableTLS == "1.3" && f5.supports(featureTLS13) {
+		server.Tls1_3Enabled = true
+		server.CipherGroup = &as3.ResourcePointer{BigIP: defaultTLS13CipherGroup}
+		if f5.Config.TLS13CipherGroupReference != "" {
+			server.CipherGroup.BigIP = f5.Config.TLS13CipherGroupReference
+		}
+		return
+	}
+	server.Ciphers = f5.Config.Ciphers
+}
@@ -1,7 +1,7 @@
 package gateway
 
 import (
-	"encoding/json"
+	"sort"
 
 	"github.com/f5devcentral/bigip-tgw/as3"
 	"github.com/f5devcentral/bigip-tgw/consul"
@@ -9,30 +9,61 @@ import (
 )
 
 var log = slog.NewLogger("f5-writer")
-var iruleEncoded = "d2hlbiBSVUxFX0lOSVQgewogICAgI3NldCBzdGF0aWM6OnNiX2RlYnVnIHRvIDIgaWYgeW91IHdhbnQgdG8gZW5hYmxlIGxvZ2dpbmcgdG8gdHJvdWJsZXNob290IHRoaXMgaVJ1bGUsIDEgZm9yIGluZm9ybWF0aW9uYWwgbWVzc2FnZXMsIG90aGVyd2lzZSBzZXQgdG8gMAogICAgc2V0IHN0YXRpYzo6c2JfZGVidWcgMgogICAgaWYgeyRzdGF0aWM6OnNiX2RlYnVnID4gMX0geyBsb2cgbG9jYWwwLiAicnVsZSBpbml0IiB9Cn0KCndoZW4gQ0xJRU5UU1NMX0NMSUVOVENFUlQgewogICAgaWYgeyRzdGF0aWM6OnNiX2RlYnVnID4gMX0ge2xvZyBsb2NhbDAuICJJbiBDTElFTlRTU0xfQ0xJRU5UQ0VSVCJ9CgogICAgc2V0IGNsaWVudF9jZXJ0IFtTU0w6OmNlcnQgMF0KICAKICAgIHNldCBzZXJpYWxfaWQgIiIKICAgIHNldCBzcGlmZmUgIiIKICAgIHNldCBsb2dfcHJlZml4ICJbSVA6OnJlbW90ZV9hZGRyXTpbVENQOjpyZW1vdGVfcG9ydCBjbGllbnRzaWRlXSBbSVA6OmxvY2FsX2FkZHJdOltUQ1A6OmxvY2FsX3BvcnQgY2xpZW50c2lkZV0iCgogICAgaWYgeyBbU1NMOjpjZXJ0IGNvdW50XSA+IDAgfSB7CiAgICAgICAgc2V0IHNwaWZmZSBbZmluZHN0ciBbWDUwOTo6ZXh0ZW5zaW9ucyBbU1NMOjpjZXJ0IDBdXSAiU3ViamVjdCBBbHRlcm5hdGl2ZSBOYW1lIiAzOSAiLCJdCiAgICAgICAgaWYgeyRzdGF0aWM6OnNiX2RlYnVnID4gMX0geyBsb2cgbG9jYWwwLiAiPCRsb2dfcHJlZml4PjogU0FOOiAkc3BpZmZlIn0KICAgICAgICBzZXQgc2VyaWFsX2lkIFtYNTA5OjpzZXJpYWxfbnVtYmVyICRjbGllbnRfY2VydF0KICAgICAgICBpZiB7JHN0YXRpYzo6c2JfZGVidWcgPiAxfSB7IGxvZyBsb2NhbDAuICI8JGxvZ19wcmVmaXg+OiBTZXJpYWxfSUQ6ICRzZXJpYWxfaWQifQogICAgfQogICAgaWYgeyRzdGF0aWM6OnNiX2RlYnVnID4gMX0geyBsb2cgbG9jYWwwLmluZm8gImhlcmUgaXMgc3BpZmZlOiAkc3BpZmZlIiB9CiAgICAgICAjcmVnZXhwIHteLipcL3tbYS16QS1aMC05XC1dKn19ICRzcGlmZmUgc3BpZmZlX3Jlc3VsdAogICAgc2V0IHNwaWZmZV9yZXN1bHQgW2dldGZpZWxkICRzcGlmZmUgIi8iIDldCiAgICBsb2cgbG9jYWwwLiAic3BpZmZlX3Jlc3VsdCArKysrKysrKysrKysrIGlzICRzcGlmZmVfcmVzdWx0IgogICAgc2V0IHRyaW1zcGlmZmUgW3N0cmluZyB0cmltICRzcGlmZmVfcmVzdWx0XQp9IAoKd2hlbiBDTElFTlRTU0xfSEFORFNIQUtFIHsKICAgIGlmIHsgW1NTTDo6ZXh0ZW5zaW9ucyBleGlzdHMgLXR5cGUgMF0gfSB7CiAgICAgICBiaW5hcnkgc2NhbiBbU1NMOjpleHRlbnNpb25zIC10eXBlIDBdIHtAOUEqfSBzbmlfbmFtZQogICAgICAgaWYgeyRzdGF0aWM6OnNiX2RlYnVnID4gMX0geyBsb2cgbG9jYWwwLiAic25pIG5hbWU6ICR7c25pX25hbWV9In0KICAgICAgIHJlZ2V4cCB7W14uXSp9ICRzbmlfbmFtZSBzbmlfcmVzdWx0CiAgICAgICBsb2cgbG9jYWwwLiAicmVzdWx0IGlzICRzbmlfcmVzdWx0IgogICAgfQoKICAgICMgdXNlIHRoZSB0ZXJuYXJ5IG9wZXJhdG9yIHRvIHJldHVybiB0aGUgc2VydmVybmFtZSBjb25kaXRpb25hbGx5CiAgICBpZiB7JHN0YXRpYzo6c2JfZGVidWcgPiAxfSB7IGxvZyBsb2NhbDAuICJzbmkgbmFtZTogW2V4cHIge1tpbmZvIGV4aXN0cyBzbmlfbmFtZV0gPyAke3NuaV9uYW1lfSA6IHtub3QgZm91bmR9IH1dIn0gICAgCiAgICAKICAgIHNldCBrZXkgW2NvbmNhdCAkdHJpbXNwaWZmZTokc25pX3Jlc3VsdF0KICAgIGxvZyBsb2NhbDAuICJoZXJlIGlzIHRoZSBrZXkgIC4uLi4gJGtleSIKICAgIGxvZyBsb2NhbDAuaW5mbyAidGFyZ2V0LWRnOiBbY2xhc3MgZ2V0IHRhcmdldC1kZ10iCiAgICBTU0w6OmhhbmRzaGFrZSBob2xkCiAgICBpZiB7W2NsYXNzIG1hdGNoICRrZXkgZXF1YWxzICJ0YXJnZXQtZGciXSB9IHsKICAgICAgICBsb2cgbG9jYWwwLiAic3VjY2VzcyIKICAgICAgICBzZXQgZ290U05JdmFsdWUgW2NsYXNzIG1hdGNoIC12YWx1ZSAiJGtleSIgZXF1YWxzICJ0YXJnZXQtZGciXQogICAgICAgIGxvZyBsb2NhbDAuICJ2YWx1ZSBpcyAkZ290U05JdmFsdWUiCiAgICB9CiAgICBlbHNlIHsKICAgICAgICBsb2cgbG9jYWwwLiAiU05JIG5vdCBpbiB0aGUgZGF0YSBncm91cCIKICAgICAgICByZWplY3QKICAgIH0KICAgIAogICAgaWYgeyAkZ290U05JdmFsdWUgZXEgImFsbG93IiB9IHRoZW4gewogICAgICAgIGxvZyBsb2NhbDAuICJ3ZSBhcmUgZ29vZCBwbGVhc2UgcHJvY2VlZCIKICAgICAgICBpZiB7JHN0YXRpYzo6c2JfZGVidWcgPiAxfSB7bG9nIGxvY2FsMC4gIklzIHRoZSBjb25uZWN0aW9uIGF1dGhvcml6ZWQ6ICRrZXkifQogICAgICAgIFNTTDo6aGFuZHNoYWtlIHJlc3VtZSAKICAgIH0KICAgIGVsc2UgewogICAgICAgIGlmIHskc3RhdGljOjpzYl9kZWJ1ZyA+IDF9IHtsb2cgbG9jYWwwLiAiQ29ubmVjdGlvbiBpcyBub3QgYXV0aG9yaXplZDogJGtleSJ9CiAgICAgICAgcmVqZWN0CiAgICB9Cn0="
+var iruleEncoded = "d2hlbiBSVUxFX0lOSVQgewogICAgI3NldCBzdGF0aWM6OnNiX2RlYnVnIHRvIDIgaWYgeW91IHdhbnQgdG8gZW5hYmxlIGxvZ2dpbmcgdG8gdHJvdWJsZXNob290IHRoaXMgaVJ1bGUsIDEgZm9yIGluZm9ybWF0aW9uYWwgbWVzc2FnZXMsIG90aGVyd2lzZSBzZXQgdG8gMAogICAgc2V0IHN0YXRpYzo6c2JfZGVidWcgMgogICAgaWYgeyRzdGF0aWM6OnNiX2RlYnVnID4gMX0geyBsb2cgbG9jYWwwLiAicnVsZSBpbml0IiB9Cn0KCndoZW4gQ0xJRU5UU1NMX0NMSUVOVENFUlQgewogICAgaWYgeyRzdGF0aWM6OnNiX2RlYnVnID4gMX0ge2xvZyBsb2NhbDAuICJJbiBDTElFTlRTU0xfQ0xJRU5UQ0VSVCJ9CgogICAgc2V0IGNsaWVudF9jZXJ0IFtTU0w6OmNlcnQgMF0KICAKICAgIHNldCBzZXJpYWxfaWQgIiIKICAgIHNldCBzcGlmZmUgIiIKICAgIHNldCBsb2dfcHJlZml4ICJbSVA6OnJlbW90ZV9hZGRyXTpbVENQOjpyZW1vdGVfcG9ydCBjbGllbnRzaWRlXSBbSVA6OmxvY2FsX2FkZHJdOltUQ1A6OmxvY2FsX3BvcnQgY2xpZW50c2lkZV0iCgogICAgaWYgeyBbU1NMOjpjZXJ0IGNvdW50XSA+IDAgfSB7CiAgICAgICAgc2V0IHNwaWZmZSBbZmluZHN0ciBbWDUwOTo6ZXh0ZW5zaW9ucyBbU1NMOjpjZXJ0IDBdXSAiU3ViamVjdCBBbHRlcm5hdGl2ZSBOYW1lIiAzOSAiLCJdCiAgICAgICAgaWYgeyRzdGF0aWM6OnNiX2RlYnVnID4gMX0geyBsb2cgbG9jYWwwLiAiPCRsb2dfcHJlZml4PjogU0FOOiAkc3BpZmZlIn0KICAgICAgICBzZXQgc2VyaWFsX2lkIFtYNTA5OjpzZXJpYWxfbnVtYmVyICRjbGllbnRfY2VydF0KICAgICAgICBpZiB7JHN0YXRpYzo6c2JfZGVidWcgPiAxfSB7IGxvZyBsb2NhbDAuICI8JGxvZ19wcmVmaXg+OiBTZXJpYWxfSUQ6ICRzZXJpYWxfaWQifQogICAgfQogICAgaWYgeyRzdGF0aWM6OnNiX2RlYnVnID4gMX0geyBsb2cgbG9jYWwwLmluZm8gImhlcmUgaXMgc3BpZmZlOiAkc3BpZmZlIiB9CiAgICAgICAjcmVnZXhwIHteLipcL3tbYS16QS1aMC05XC1dKn19ICRzcGlmZmUgc3BpZmZlX3Jlc3VsdAogICAgc2V0IHNwaWZmZV9yZXN1bHQgW2dldGZpZWxkICRzcGlmZmUgIi8iIDldCiAgICBsb2cgbG9jYWwwLiAic3BpZmZlX3Jlc3VsdCArKysrKysrKysrKysrIGlzICRzcGlmZmVfcmVzdWx0IgogICAgc2V0IHRyaW1zcGlmZmUgW3N0cmluZyB0cmltICRzcGlmZmVfcmVzdWx0XQp9IAoKd2hlbiBDTElFTlRTU0xfSEFORFNIQUtFIHsKICAgICMgdGhlIGZ1bGwgU05JIG9mIHRoZSBzZXJ2aWNlIGlzIG1hdGNoZWQsIGNsaWVudHMgd2l0aG91dCBTTkkgYXJlIHJlamVjdGVkCiAgICBzZXQgc25pX3Jlc3VsdCAiIgogICAgaWYgeyBbU1NMOjpleHRlbnNpb25zIGV4aXN0cyAtdHlwZSAwXSB9IHsKICAgICAgIGJpbmFyeSBzY2FuIFtTU0w6OmV4dGVuc2lvbnMgLXR5cGUgMF0ge0A5QSp9IHNuaV9uYW1lCiAgICAgICBpZiB7JHN0YXRpYzo6c2JfZGVidWcgPiAxfSB7IGxvZyBsb2NhbDAuICJzbmkgbmFtZTogJHtzbmlfbmFtZX0ifQogICAgICAgc2V0IHNuaV9yZXN1bHQgW3N0cmluZyB0b2xvd2VyICRzbmlfbmFtZV0KICAgICAgIGxvZyBsb2NhbDAuICJyZXN1bHQgaXMgJHNuaV9yZXN1bHQiCiAgICB9CgogICAgIyB1c2UgdGhlIHRlcm5hcnkgb3BlcmF0b3IgdG8gcmV0dXJuIHRoZSBzZXJ2ZXJuYW1lIGNvbmRpdGlvbmFsbHkKICAgIGlmIHskc3RhdGljOjpzYl9kZWJ1ZyA+IDF9IHsgbG9nIGxvY2FsMC4gInNuaSBuYW1lOiBbZXhwciB7W2luZm8gZXhpc3RzIHNuaV9uYW1lXSA/ICR7c25pX25hbWV9IDoge25vdCBmb3VuZH0gfV0ifSAgICAKICAgIAogICAgc2V0IGtleSBbY29uY2F0ICR0cmltc3BpZmZlOiRzbmlfcmVzdWx0XQogICAgbG9nIGxvY2FsMC4gImhlcmUgaXMgdGhlIGtleSAgLi4uLiAka2V5IgogICAgbG9nIGxvY2FsMC5pbmZvICJ0YXJnZXQtZGc6IFtjbGFzcyBnZXQgdGFyZ2V0LWRnXSIKICAgIFNTTDo6aGFuZHNoYWtlIGhvbGQKICAgIGlmIHtbY2xhc3MgbWF0Y2ggJGtleSBlcXVhbHMgInRhcmdldC1kZyJdIH0gewogICAgICAgIGxvZyBsb2NhbDAuICJzdWNjZXNzIgogICAgICAgIHNldCBnb3RTTkl2YWx1ZSBbY2xhc3MgbWF0Y2ggLXZhbHVlICIka2V5IiBlcXVhbHMgInRhcmdldC1kZyJdCiAgICAgICAgbG9nIGxvY2FsMC4gInZhbHVlIGlzICRnb3RTTkl2YWx1ZSIKICAgIH0KICAgIGVsc2UgewogICAgICAgIGxvZyBsb2NhbDAuICJTTkkgbm90IGluIHRoZSBkYXRhIGdyb3VwIgogICAgICAgIHJlamVjdAogICAgICAgIHJldHVybgogICAgfQogICAgCiAgICBpZiB7ICRnb3RTTkl2YWx1ZSBlcSAiYWxsb3ciIH0gdGhlbiB7CiAgICAgICAgbG9nIGxvY2FsMC4gIndlIGFyZSBnb29kIHBsZWFzZSBwcm9jZWVkIgogICAgICAgIGlmIHskc3RhdGljOjpzYl9kZWJ1ZyA+IDF9IHtsb2cgbG9jYWwwLiAiSXMgdGhlIGNvbm5lY3Rpb24gYXV0aG9yaXplZDogJGtleSJ9CiAgICAgICAgU1NMOjpoYW5kc2hha2UgcmVzdW1lIAogICAgfQogICAgZWxzZSB7CiAgICAgICAgaWYgeyRzdGF0aWM6OnNiX2RlYnVnID4gMX0ge2xvZyBsb2NhbDAuICJDb25uZWN0aW9uIGlzIG5vdCBhdXRob3JpemVkOiAka2V5In0KICAgICAgICByZWplY3QKICAgIH0KfQ=="
 var teemUAgent = "TGW Configured AS3"
 
+// Options are the per gateway settings of the writer
+type Options struct {
+	// Tenant is the AS3 tenant owned by the gateway
+	Tenant string
+	// Application and VirtualServer name the AS3 application of the tenant
+	// and the virtual server of the gateway
+	Application   string
+	VirtualServer string
+	// TCPMonitorFallback monitors the pools of services without usable
+	// Consul checks with the built-in tcp monitor
+	TCPMonitorFallback bool
+	// UpstreamTLSDir holds the PEM files referenced by the CAFile, CertFile
+	// and KeyFile of the terminating gateway config entry
+	UpstreamTLSDir string
+	// SchemaVersion is declared in the declarations and decides which AS3
+	// properties are used, see featureVersions
+	SchemaVersion as3.Version
+	// Schema is the $schema of the declarations, left out when empty
+	Schema string
+}
+
 type Bigip struct {
-	Config as3.Params
+	Config  as3.Params
+	Options Options
 	//Session bigip.BigIP
 	CfgC    chan consul.Config
 	ReqChan chan as3.AS3Config
 
 	AS3Config *as3.AS3Config
+	// unsupported holds the features already reported as unsupported
+	unsupported map[feature]bool
+	// stopped is closed when Run returns
+	stopped chan struct{}
 }
 
-func New(c as3.Params, watcherChan chan consul.Config, reqChan chan as3.AS3Config) *Bigip {
-	log.Info("[INIT] Creating AS3 writer")
+func New(c as3.Params, opts Options, watcherChan chan consul.Config, reqChan chan as3.AS3Config) *Bigip {
+	log.Infof("[INIT] Creating AS3 writer for tenant %s", opts.Tenant)
 
 	return &Bigip{
 		Config:  c,
+		Options: opts,
 		CfgC:    watcherChan,
 		ReqChan: reqChan,
+		stopped: make(chan struct{}),
 	}
 }
 
+// DeInit stops the writer once the watcher feeding CfgC is stopped. The
+// declaration being deployed is handed to the agent before ReqChan is closed.
 func (f5 *Bigip) DeInit() error {
 	close(f5.CfgC)
+	<-f5.stopped
 	close(f5.ReqChan)
 	return nil
 }
@@ -48,20 +79,50 @@ func (f5 *Bigip) Deploy(req as3.AS3Config) error {
 	return nil
 }
 
+// TenantName derives the AS3 tenant owned by a gateway from its name
+func TenantName(gatewayName string) string {
+	return gatewayObjectName(gatewayName, "")
+}
+
+// ApplicationName derives the AS3 application of a gateway from its name
+func ApplicationName(gatewayName string) string {
+	return gatewayObjectName(gatewayName, "_app")
+}
+
+// VirtualServerName derives the virtual server of a gateway from its name
+func VirtualServerName(gatewayName string) string {
+	return gatewayObjectName(gatewayName, "_vs")
+}
+
+func gatewayObjectName(gatewayName string, suffix string) string {
+	name := []byte("TGW_" + gatewayName + suffix)
+	for i, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
+
 func (f5 *Bigip) Run() error {
+	defer close(f5.stopped)
 	//go func() {
 	for c := range f5.CfgC {
 		log.Info("[INFO] Writer received configuration change")
 
 		//Construct New AS3 Config
-		f5.makeAppMap(c)
+		if err := f5.makeAppMap(c); err != nil {
+			log.Errorf("[ERROR] Unable to build AS3 declaration for tenant %s, keeping the deployed configuration: %v", f5.Options.Tenant, err)
+			continue
+		}
 
 		//Get AS3 JSON from Structs
-		jsonObj, err := json.Marshal(f5.AS3Config)
+		jsonObj, err := stampDeclaration(f5.AS3Config)
 		if err != nil {
 			log.Error(err)
 		}
 		f5.AS3Config.JsonObj = string(jsonObj)
+		f5.AS3Config.ConsulIndexes = c.Indexes
 
 		log.Debugf("[DEBUG] AS3 Declaration: %v", string(jsonObj))
 
@@ -72,20 +133,23 @@ func (f5 *Bigip) Run() error {
 
 func (f5 *Bigip) newAS3Config() *as3.AS3Config {
 	stubConfig := as3.AS3Config{
-		Schema:  f5.Config.Schema,
+		Schema:  f5.Options.Schema,
 		Class:   "AS3",
 		Action:  "deploy",
 		Persist: true,
 		Declaration: as3.Declaration{
 			Class:         "ADC",
-			SchemaVersion: f5.Config.SchemaVersion,
+			SchemaVersion: f5.Options.SchemaVersion.String(),
 			Controls: &as3.Controls{
 				Class:     "Controls",
 				UserAgent: teemUAgent,
 			},
 			Tenant: as3.Tenant{
+				Name:               f5.Options.Tenant,
 				Class:              "Tenant",
+				Label:              as3.OwnerLabel,
 				DefaultRouteDomain: 0,
+				ApplicationName:    f5.Options.Application,
 				Application:        make(map[string]interface{}),
 			},
 		},
@@ -94,29 +158,53 @@ func (f5 *Bigip) newAS3Config() *as3.AS3Config {
 	return &stubConfig
 }
 
-func (f5 *Bigip) makeAppMap(c consul.Config) {
+func (f5 *Bigip) makeAppMap(c consul.Config) error {
+	c.Services = uniqueServices(c.Services)
+
 	f5.AS3Config = f5.newAS3Config()
 	f5.AS3Config.Declaration.Tenant.Application["class"] = "Application"
 	f5.AS3Config.Declaration.Tenant.Application["template"] = "generic"
 
-	vServer := makeVserver(c)
+	vServer := makeVserver(c, f5.Options.VirtualServer)
 	f5.AS3Config.Declaration.Tenant.Application[vServer.Name] = vServer
 
-	pools := makePools(c)
+	pools := makePools(c, f5.Options.TCPMonitorFallback)
 	for _, p := range pools {
 		f5.AS3Config.Declaration.Tenant.Application[p.Name] = p
 	}
 
+	monitors := makeMonitors(c)
+	for _, m := range monitors {
+		f5.AS3Config.Declaration.Tenant.Application[m.Name] = m
+	}
+
 	CAs := makeCAs(c)
 	f5.AS3Config.Declaration.Tenant.Application[CAs.Name] = CAs
 
 	serverTLS := makeServerTLS(c)
+	f5.applyTLSVersion(&serverTLS)
 	f5.AS3Config.Declaration.Tenant.Application[serverTLS.Name] = serverTLS
 
-	//proxyTLS := makeProxyTLS(c)
-	//for _, p := range proxyTLS {
-	//	nextAS3.Declaration.Tenant.Application[p.Name] = p
-	//}
+	proxyTLS, err := makeProxyTLS(c, f5.Options.UpstreamTLSDir)
+	if err != nil {
+		return err
+	}
+	for _, t := range proxyTLS.clients {
+		f5.AS3Config.Declaration.Tenant.Application[t.Name] = t
+	}
+	for _, cert := range proxyTLS.certs {
+		f5.AS3Config.Declaration.Tenant.Application[cert.Name] = cert
+	}
+	for _, ca := range proxyTLS.cas {
+		f5.AS3Config.Declaration.Tenant.Application[ca.Name] = ca
+	}
+	if len(proxyTLS.clients) > 0 {
+		vServer.ClientTLS = proxyTLS.clients[0].Name
+		vServer.IRules = append(vServer.IRules, proxyTLS.iRule.Name)
+		f5.AS3Config.Declaration.Tenant.Application[proxyTLS.iRule.Name] = proxyTLS.iRule
+		f5.AS3Config.Declaration.Tenant.Application[proxyTLS.datagroup.Name] = proxyTLS.datagroup
+	}
+
 	certs := makeCerts(c)
 	for _, c := range certs {
 		f5.AS3Config.Declaration.Tenant.Application[c.Name] = c
@@ -134,22 +222,30 @@ func (f5 *Bigip) makeAppMap(c consul.Config) {
 	for _, d := range datagroups {
 		f5.AS3Config.Declaration.Tenant.Application[d.Name] = d
 	}
+	return nil
 }
 
-func makePools(c consul.Config) []as3.Pool {
+func makePools(c consul.Config, tcpFallback bool) []as3.Pool {
 	pools := []as3.Pool{}
 
 	for _, s := range c.Services {
 		poolx := newPool()
-		poolx.Name = s.Name + "-pool"
+		poolx.Name = poolName(s.Name)
+		poolx.Remark = serviceRemark(s.Name)
+		applyServiceMeta(poolx, s)
+		poolx.Monitors = poolMonitors(s, tcpFallback)
 
 		// Add Pool Members
+		queryInterval := fqdnQueryInterval(s)
 		for _, i := range s.Instances {
-			poolx.Members = append(poolx.Members, as3.Member{
-				ServicePort:     i.Port,
-				ServerAddresses: []string{i.Address},
-			})
+			member := newMember(i, queryInterval)
+			// Members in maintenance keep their connections until they drain
+			if i.Maintenance() {
+				member.AdminState = "disable"
+			}
+			poolx.Members = append(poolx.Members, member)
 		}
+		applyWeights(poolx, s)
 
 		pools = append(pools, *poolx)
 	}
@@ -164,7 +260,7 @@ func makePolicies(c consul.Config) as3.PolicyEndpoint {
 
 	for _, s := range c.Services {
 		myRule := &as3.PolicyRule{
-			Name: "forward_to_" + s.Name,
+			Name: ruleName(s.Name),
 		}
 		myCondition := &as3.Condition{
 			Type:       "sslExtension",
@@ -172,9 +268,9 @@ func makePolicies(c consul.Config) as3.PolicyEndpoint {
 			Normalized: false,
 		}
 		myCondition.ServerName = &as3.PolicyCompareString{}
-		myCondition.ServerName.Operand = "starts-with"
+		myCondition.ServerName.Operand = "equals"
 		myCondition.ServerName.CaseSensitive = false
-		myCondition.ServerName.Values = append(myCondition.ServerName.Values, s.Name)
+		myCondition.ServerName.Values = append(myCondition.ServerName.Values, s.SNI)
 		myRule.Conditions = append(myRule.Conditions, myCondition)
 
 		myAction := &as3.Action{
@@ -183,7 +279,7 @@ func makePolicies(c consul.Config) as3.PolicyEndpoint {
 		}
 		myAction.Select = &as3.ActionForwardSelect{}
 		myAction.Select.Pool = &as3.ResourcePointer{}
-		myAction.Select.Pool.Use = s.Name + "-pool"
+		myAction.Select.Pool.Use = poolName(s.Name)
 		myRule.Actions = append(myRule.Actions, myAction)
 		mySNI.Rules = append(mySNI.Rules, myRule)
 	}
@@ -201,38 +297,19 @@ func makeServerTLS(c consul.Config) as3.ServerTLS {
 
 	for _, s := range c.Services {
 		server.Certificates = append(server.Certificates, as3.CertName{
-			Certificate: s.Name + "-cert",
+			Certificate: certName(s.Name),
 		})
 	}
 	return server
 }
 
-func makeProxyTLS(c consul.Config) []as3.ClientTLS {
-	proxyTLS := []as3.ClientTLS{}
-	for _, s := range c.Services {
-		if s.ProxyTLS != nil {
-			myProxyTLS := &as3.ClientTLS{
-				Name:                s.Name + "-proxytls",
-				Class:               "TLS_Client",
-				SendSNI:             s.ProxyTLS.SNI,
-				ValidateCertificate: true,
-				TrustCA:             s.ProxyTLS.CAFile,
-				IgnoreExpired:       false,
-				IgnoreUntrusted:     false,
-				ClientCertificate:   s.Name + "-proxycert",
-			}
-			proxyTLS = append(proxyTLS, *myProxyTLS)
-		}
-	}
-	return proxyTLS
-}
-
 func makeCerts(c consul.Config) []as3.Certificate {
 	var certs = []as3.Certificate{}
 
 	for _, s := range c.Services {
 		newCert := as3.Certificate{
-			Name:        s.Name + "-cert",
+			Name:        certName(s.Name),
+			Remark:      serviceRemark(s.Name),
 			Class:       "Certificate",
 			Certificate: s.CertString(),
 			PrivateKey:  s.KeyString(),
@@ -279,17 +356,20 @@ func makeDatagroups(c consul.Config) []as3.DataGroup {
 	for _, s := range c.Services {
 		for _, i := range s.Intentions {
 			intentions.Records = append(intentions.Records, &as3.Record{
-				Key:   i + ":" + s.Name,
+				Key:   i + ":" + s.SNI,
 				Value: "allow",
 			})
 		}
 	}
+	sort.Slice(intentions.Records, func(i, j int) bool {
+		return intentions.Records[i].Key < intentions.Records[j].Key
+	})
 	datagroups = append(datagroups, intentions)
 	return datagroups
 }
-func makeVserver(c consul.Config) *as3.Service {
+func makeVserver(c consul.Config, name string) *as3.Service {
 	stubVserver := as3.Service{
-		Name:           "TG_Vserver",
+		Name:           name,
 		Class:          "Service_TCP",
 		ServerTLS:      "webtls",
 		PolicyEndpoint: "SNIrouting",
@@ -0,0 +1,28 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/f5devcentral/bigip-tgw/as3"
+)
+
+// stampDeclaration renders the declaration with a hash of its content as id
+// and in the remark, so that identical Consul state produces byte identical
+// AS3 and a deployed declaration can be traced back to what generated it
+func stampDeclaration(cfg *as3.AS3Config) ([]byte, error) {
+	cfg.Declaration.ID = ""
+	cfg.Declaration.Remark = ""
+	content, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	cfg.Declaration.ID = "tgw-" + hash
+	// remarks are limited to 64 characters
+	cfg.Declaration.Remark = "bigip-tgw content " + hash[:16]
+	return json.Marshal(cfg)
+}
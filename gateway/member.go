@@ -0,0 +1,45 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/f5devcentral/bigip-tgw/as3"
+	"github.com/f5devcentral/bigip-tgw/consul"
+)
+
+// maxQueryInterval is the largest FQDN query interval accepted by AS3
+const maxQueryInterval = 604800
+
+// newMember renders an instance as a pool member. Instances registered with
+// a hostname are resolved by the BIG-IP, every address returned by DNS
+// becomes a member of the pool.
+func newMember(i *consul.Instance, queryInterval int) as3.Member {
+	if i.AddressType != consul.AddressHostname {
+		return as3.Member{
+			ServicePort:     i.Port,
+			ServerAddresses: []string{i.Address},
+		}
+	}
+	return as3.Member{
+		ServicePort:      i.Port,
+		AddressDiscovery: "fqdn",
+		Hostname:         i.Address,
+		AutoPopulate:     true,
+		QueryInterval:    queryInterval,
+	}
+}
+
+// fqdnQueryInterval returns the DNS query interval set in the service
+// metadata, 0 lets the BIG-IP follow the TTL of the records
+func fqdnQueryInterval(s consul.Service) int {
+	value, ok := s.Meta[metaFQDNQueryInterval]
+	if !ok {
+		return 0
+	}
+	seconds, err := parseSeconds(strings.TrimSpace(value))
+	if err != nil || seconds < 0 || seconds > maxQueryInterval {
+		log.Warnf("[WARN] service %s: ignoring invalid %s %q, expected seconds between 0 and %d", s.Name, metaFQDNQueryInterval, value, maxQueryInterval)
+		return 0
+	}
+	return seconds
+}
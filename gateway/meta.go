@@ -0,0 +1,116 @@
+package gateway
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/f5devcentral/bigip-tgw/as3"
+	"github.com/f5devcentral/bigip-tgw/consul"
+)
+
+// Consul service metadata keys that tune the pool of a service
+const (
+	metaLBMode            = consul.MetaPrefix + "lb-mode"
+	metaSlowRamp          = consul.MetaPrefix + "slow-ramp"
+	metaMinActive         = consul.MetaPrefix + "min-active"
+	metaServiceDownAction = consul.MetaPrefix + "service-down-action"
+	metaReselectTries     = consul.MetaPrefix + "reselect-tries"
+	metaFQDNQueryInterval = consul.MetaPrefix + "fqdn-query-interval"
+)
+
+var lbModes = map[string]bool{
+	"dynamic-ratio-member":              true,
+	"dynamic-ratio-node":                true,
+	"fastest-app-response":              true,
+	"fastest-node":                      true,
+	"least-connections-member":          true,
+	"least-connections-node":            true,
+	"least-sessions":                    true,
+	"observed-member":                   true,
+	"observed-node":                     true,
+	"predictive-member":                 true,
+	"predictive-node":                   true,
+	"ratio-least-connections-member":    true,
+	"ratio-least-connections-node":      true,
+	"ratio-member":                      true,
+	"ratio-node":                        true,
+	"ratio-session":                     true,
+	"round-robin":                       true,
+	"weighted-least-connections-member": true,
+	"weighted-least-connections-node":   true,
+}
+
+var serviceDownActions = map[string]bool{
+	"drop":     true,
+	"none":     true,
+	"reselect": true,
+	"reset":    true,
+}
+
+// applyServiceMeta tunes a pool from the metadata of its Consul service.
+// Invalid values are logged and skipped so that a single service cannot
+// fail the whole declaration.
+func applyServiceMeta(pool *as3.Pool, s consul.Service) {
+	keys := make([]string, 0, len(s.Meta))
+	for k := range s.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := strings.TrimSpace(s.Meta[key])
+		switch key {
+		case metaLBMode:
+			if !lbModes[value] {
+				log.Warnf("[WARN] service %s: ignoring invalid %s %q", s.Name, key, value)
+				continue
+			}
+			pool.LoadBalancingMode = value
+		case metaServiceDownAction:
+			if !serviceDownActions[value] {
+				log.Warnf("[WARN] service %s: ignoring invalid %s %q", s.Name, key, value)
+				continue
+			}
+			pool.ServiceDownAction = value
+		case metaSlowRamp:
+			seconds, err := parseSeconds(value)
+			if err != nil || seconds < 0 || seconds > 900 {
+				log.Warnf("[WARN] service %s: ignoring invalid %s %q, expected seconds between 0 and 900", s.Name, key, value)
+				continue
+			}
+			pool.SlowRampTime = &seconds
+		case metaMinActive:
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || n > 65535 {
+				log.Warnf("[WARN] service %s: ignoring invalid %s %q, expected a number between 0 and 65535", s.Name, key, value)
+				continue
+			}
+			pool.MinimumMembersActive = &n
+		case metaReselectTries:
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || n > 65535 {
+				log.Warnf("[WARN] service %s: ignoring invalid %s %q, expected a number between 0 and 65535", s.Name, key, value)
+				continue
+			}
+			pool.ReselectTries = &n
+		case metaFQDNQueryInterval:
+			// applied to the hostname members by newMember
+		default:
+			log.Warnf("[WARN] service %s: ignoring unknown metadata %s", s.Name, key)
+		}
+	}
+}
+
+// parseSeconds accepts a number of seconds or a duration such as 30s or 2m
+func parseSeconds(value string) (int, error) {
+	if n, err := strconv.Atoi(value); err == nil {
+		return n, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	return int(d / time.Second), nil
+}
@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/f5devcentral/bigip-tgw/as3"
+	"github.com/f5devcentral/bigip-tgw/consul"
+)
+
+const (
+	defaultMonitorInterval = 5 * time.Second
+	tcpFallbackMonitor     = "/Common/tcp"
+)
+
+func monitorName(service string, n int) string {
+	return fmt.Sprintf("%s-monitor-%d", objectName(service), n)
+}
+
+// poolMonitors points a pool at the monitors made from its service checks
+func poolMonitors(s consul.Service, tcpFallback bool) []as3.ResourcePointer {
+	monitors := []as3.ResourcePointer{}
+	for n := range s.Checks {
+		monitors = append(monitors, as3.ResourcePointer{Use: monitorName(s.Name, n)})
+	}
+	if len(monitors) == 0 && tcpFallback {
+		monitors = append(monitors, as3.ResourcePointer{BigIP: tcpFallbackMonitor})
+	}
+	return monitors
+}
+
+// makeMonitors translates the Consul checks of every service into monitors
+func makeMonitors(c consul.Config) []as3.Monitor {
+	var monitors []as3.Monitor
+	for _, s := range c.Services {
+		for n, check := range s.Checks {
+			monitor := newMonitor(monitorName(s.Name, n), check)
+			monitor.Remark = serviceRemark(s.Name)
+			monitors = append(monitors, monitor)
+		}
+	}
+	return monitors
+}
+
+// newMonitor probes like the Consul check does. BIG-IP marks a member down
+// when no probe succeeds within the timeout, so it spans several intervals
+// rather than the timeout of a single Consul probe.
+func newMonitor(name string, check consul.Check) as3.Monitor {
+	interval := check.Interval
+	if interval <= 0 {
+		interval = defaultMonitorInterval
+	}
+	intervalSeconds := int(math.Ceil(interval.Seconds()))
+	timeoutSeconds := 3*intervalSeconds + 1
+	if t := int(math.Ceil((check.Timeout + interval).Seconds())); t > timeoutSeconds {
+		timeoutSeconds = t
+	}
+
+	monitor := as3.Monitor{
+		Name:        name,
+		Class:       "Monitor",
+		MonitorType: check.Type,
+		Interval:    intervalSeconds,
+		Timeout:     timeoutSeconds,
+	}
+	if check.Type == "http" || check.Type == "https" {
+		// The monitor is shared by all members, without an explicit Host
+		// header HTTP/1.0 avoids sending the address of a single instance
+		if check.Host != "" {
+			monitor.Send = fmt.Sprintf("%s %s HTTP/1.1\r\nHost: %s\r\nConnection: Close\r\n\r\n",
+				strings.ToUpper(check.Method), check.Path, check.Host)
+		} else {
+			monitor.Send = fmt.Sprintf("%s %s HTTP/1.0\r\n\r\n", strings.ToUpper(check.Method), check.Path)
+		}
+		// Consul considers any 2xx response as passing
+		monitor.Receive = "HTTP/1\\.[01] 2[0-9][0-9]"
+	}
+	return monitor
+}
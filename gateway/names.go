@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/f5devcentral/bigip-tgw/consul"
+)
+
+const (
+	// maxBaseName leaves room for the suffixes and prefixes added to the
+	// service name while staying well below the AS3 name length limits
+	maxBaseName = 40
+	// maxRemark is the AS3 limit for labels and remarks
+	maxRemark = 64
+)
+
+var (
+	invalidNameChars = regexp.MustCompile(`[^0-9A-Za-z_-]`)
+	validName        = regexp.MustCompile(`^[A-Za-z][0-9A-Za-z_-]*$`)
+)
+
+// objectName turns a Consul service name into the base of its AS3 object
+// names. Names that AS3 accepts are kept, others are rewritten and suffixed
+// with a hash of the original so that they cannot collide with each other.
+func objectName(service string) string {
+	if len(service) <= maxBaseName && validName.MatchString(service) {
+		return service
+	}
+	sum := sha256.Sum256([]byte(service))
+	suffix := "_" + hex.EncodeToString(sum[:])[:8]
+
+	name := invalidNameChars.ReplaceAllString(service, "_")
+	if name == "" || !validName.MatchString(name[:1]) {
+		name = "s_" + name
+	}
+	if len(name) > maxBaseName-len(suffix) {
+		name = name[:maxBaseName-len(suffix)]
+	}
+	return name + suffix
+}
+
+func poolName(service string) string {
+	return objectName(service) + "-pool"
+}
+
+func certName(service string) string {
+	return objectName(service) + "-cert"
+}
+
+func ruleName(service string) string {
+	return "forward_to_" + objectName(service)
+}
+
+// serviceRemark records the Consul name on the objects of a service
+func serviceRemark(service string) string {
+	if len(service) > maxRemark {
+		return service[:maxRemark]
+	}
+	return service
+}
+
+// uniqueServices drops the services whose AS3 names collide with the name
+// of a previous one. Services are sorted by name, the same service is
+// dropped on every run.
+func uniqueServices(services []consul.Service) []consul.Service {
+	unique := make([]consul.Service, 0, len(services))
+	owner := make(map[string]string)
+	for _, s := range services {
+		name := objectName(s.Name)
+		if other, ok := owner[name]; ok {
+			log.Errorf("[ERROR] service %s is left out, its AS3 name %s collides with service %s", s.Name, name, other)
+			continue
+		}
+		owner[name] = s.Name
+		unique = append(unique, s)
+	}
+	return unique
+}
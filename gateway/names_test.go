@@ -0,0 +1,88 @@
+package gateway
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/f5devcentral/bigip-tgw/consul"
+)
+
+func TestObjectName(t *testing.T) {
+	tests := []struct {
+		service string
+		want    string
+	}{
+		{"api", "api"},
+		{"api-v2", "api-v2"},
+		{"api_v2", "api_v2"},
+		{strings.Repeat("a", 40), strings.Repeat("a", 40)},
+		// rewritten names carry the first 8 hex digits of the sha256 of the Consul name
+		{"api.v2", "api_v2_ba81b846"},
+		{"1api", "s_1api_7319e886"},
+		{"ümlaut", "s__mlaut_e30af24e"},
+		{"", "s__e3b0c442"},
+		// long names are truncated so that the suffix keeps them at 40 characters
+		{strings.Repeat("a", 50), strings.Repeat("a", 31) + "_160b4e43"},
+	}
+	for _, tt := range tests {
+		got := objectName(tt.service)
+		if got != tt.want {
+			t.Errorf("objectName(%q) = %q, want %q", tt.service, got, tt.want)
+		}
+		if len(got) > maxBaseName || !validName.MatchString(got) {
+			t.Errorf("objectName(%q) = %q is not a valid AS3 base name", tt.service, got)
+		}
+	}
+}
+
+func TestObjectNameStable(t *testing.T) {
+	long := strings.Repeat("b", 41)
+	if objectName(long) != objectName(long) {
+		t.Errorf("objectName(%q) is not stable", long)
+	}
+	if objectName(long) == objectName(long+"c") {
+		t.Errorf("truncated names of different services collide")
+	}
+}
+
+func TestDerivedNames(t *testing.T) {
+	if got := poolName("api.v2"); got != "api_v2_ba81b846-pool" {
+		t.Errorf("poolName = %q", got)
+	}
+	if got := certName("api"); got != "api-cert" {
+		t.Errorf("certName = %q", got)
+	}
+	if got := ruleName("api"); got != "forward_to_api" {
+		t.Errorf("ruleName = %q", got)
+	}
+	if got := serviceRemark(strings.Repeat("r", 70)); len(got) != maxRemark {
+		t.Errorf("serviceRemark is %d characters long, want %d", len(got), maxRemark)
+	}
+}
+
+func TestUniqueServices(t *testing.T) {
+	tests := []struct {
+		name     string
+		services []string
+		want     []string
+	}{
+		{"distinct", []string{"api", "api.v2", "web"}, []string{"api", "api.v2", "web"}},
+		// api_v2_ba81b846 is the AS3 name of api.v2
+		{"collision", []string{"api.v2", "api_v2_ba81b846", "web"}, []string{"api.v2", "web"}},
+		{"first wins", []string{"api_v2_ba81b846", "api.v2"}, []string{"api_v2_ba81b846"}},
+	}
+	for _, tt := range tests {
+		var services []consul.Service
+		for _, name := range tt.services {
+			services = append(services, consul.Service{Name: name})
+		}
+		var got []string
+		for _, s := range uniqueServices(services) {
+			got = append(got, s.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: uniqueServices(%v) = %v, want %v", tt.name, tt.services, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/f5devcentral/bigip-tgw/as3"
+	"github.com/f5devcentral/bigip-tgw/consul"
+)
+
+const (
+	// maxRatio is the largest pool member ratio accepted by AS3
+	maxRatio         = 100
+	defaultRatioMode = "ratio-member"
+)
+
+// applyWeights renders Consul instance weights as member ratios. Members are
+// expected in the same order as the instances. When weights differ and the
+// service did not choose a load balancing mode, ratio-member is used.
+func applyWeights(pool *as3.Pool, s consul.Service) {
+	if len(s.Instances) == 0 {
+		return
+	}
+	max := 0
+	uniform := true
+	for _, i := range s.Instances {
+		if i.Weight > max {
+			max = i.Weight
+		}
+		if i.Weight != s.Instances[0].Weight {
+			uniform = false
+		}
+	}
+	if uniform {
+		return
+	}
+
+	for n, i := range s.Instances {
+		ratio := i.Weight
+		// Consul weights go up to 65535, scale them down to what AS3 accepts
+		if max > maxRatio {
+			ratio = i.Weight * maxRatio / max
+			if ratio == 0 && i.Weight > 0 {
+				ratio = 1
+			}
+		}
+		pool.Members[n].Ratio = &ratio
+	}
+
+	if pool.LoadBalancingMode == "" {
+		pool.LoadBalancingMode = defaultRatioMode
+	} else if !strings.Contains(pool.LoadBalancingMode, "ratio") {
+		log.Warnf("[WARN] service %s: instance weights differ but load balancing mode %s ignores ratios", s.Name, pool.LoadBalancingMode)
+	}
+}
@@ -0,0 +1,146 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/f5devcentral/bigip-tgw/as3"
+	"github.com/f5devcentral/bigip-tgw/consul"
+)
+
+const (
+	upstreamTLSRuleName = "upstreamTLSRule"
+	upstreamTLSDGName   = "upstream-tls-dg"
+)
+
+// upstreamTLSRule selects the server side TLS profile of the pool picked by
+// the SNI routing policy and keeps the traffic toward other pools in clear
+// text. The virtual server carries one of the profiles so that it can be
+// swapped.
+var upstreamTLSRule = `when SERVER_CONNECTED {
+    set pool [LB::server pool]
+    set profile [class match -value [getfield $pool "/" 4] equals ` + upstreamTLSDGName + `]
+    if { $profile ne "" } {
+        SSL::profile "[join [lrange [split $pool "/"] 0 2] "/"]/$profile"
+    } else {
+        SSL::disable serverside
+    }
+}
+`
+
+type proxyTLS struct {
+	clients   []as3.ClientTLS
+	certs     []as3.Certificate
+	cas       []as3.CABundle
+	iRule     as3.IRule
+	datagroup as3.DataGroup
+}
+
+// makeProxyTLS originates TLS toward the services that have TLS settings in
+// the terminating gateway config entry. It fails when a referenced file
+// cannot be used rather than sending the traffic in clear text.
+func makeProxyTLS(c consul.Config, dir string) (*proxyTLS, error) {
+	upstream := &proxyTLS{
+		iRule: as3.IRule{
+			Name:  upstreamTLSRuleName,
+			Class: "iRule",
+			IRule: &as3.ResourcePointer{
+				Base64: base64.StdEncoding.EncodeToString([]byte(upstreamTLSRule)),
+			},
+		},
+		datagroup: as3.DataGroup{
+			Class:       "Data_Group",
+			StorageType: "internal",
+			Name:        upstreamTLSDGName,
+			KeyDataType: "string",
+		},
+	}
+
+	for _, s := range c.Services {
+		if s.ProxyTLS == nil {
+			continue
+		}
+		client := as3.ClientTLS{
+			Name:                objectName(s.Name) + "-proxytls",
+			Class:               "TLS_Client",
+			Label:               "TLS Origination",
+			Remark:              serviceRemark(s.Name),
+			SendSNI:             s.ProxyTLS.SNI,
+			ValidateCertificate: true,
+			IgnoreExpired:       false,
+			IgnoreUntrusted:     false,
+		}
+
+		if s.ProxyTLS.CAFile != "" {
+			ca, err := readPEM(dir, s.ProxyTLS.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("service %s: CAFile: %v", s.Name, err)
+			}
+			upstream.cas = append(upstream.cas, as3.CABundle{
+				Name:   objectName(s.Name) + "-proxyca",
+				Class:  "CA_Bundle",
+				Remark: serviceRemark(s.Name),
+				Bundle: string(ca),
+			})
+			client.TrustCA = objectName(s.Name) + "-proxyca"
+		}
+
+		if s.ProxyTLS.CertFile != "" || s.ProxyTLS.KeyFile != "" {
+			if s.ProxyTLS.CertFile == "" || s.ProxyTLS.KeyFile == "" {
+				return nil, fmt.Errorf("service %s: CertFile and KeyFile have to be set together", s.Name)
+			}
+			cert, err := readPEM(dir, s.ProxyTLS.CertFile)
+			if err != nil {
+				return nil, fmt.Errorf("service %s: CertFile: %v", s.Name, err)
+			}
+			key, err := readPEM(dir, s.ProxyTLS.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("service %s: KeyFile: %v", s.Name, err)
+			}
+			if _, err := tls.X509KeyPair(cert, key); err != nil {
+				return nil, fmt.Errorf("service %s: CertFile and KeyFile do not form a key pair: %v", s.Name, err)
+			}
+			upstream.certs = append(upstream.certs, as3.Certificate{
+				Name:        objectName(s.Name) + "-proxycert",
+				Class:       "Certificate",
+				Remark:      serviceRemark(s.Name),
+				Certificate: string(cert),
+				PrivateKey:  string(key),
+			})
+			client.ClientCertificate = objectName(s.Name) + "-proxycert"
+		}
+
+		upstream.clients = append(upstream.clients, client)
+		upstream.datagroup.Records = append(upstream.datagroup.Records, &as3.Record{
+			Key:   poolName(s.Name),
+			Value: client.Name,
+		})
+	}
+	return upstream, nil
+}
+
+// readPEM reads a file of the config entry from the upstream TLS directory.
+// The paths are the ones seen by the gateway, they are resolved below dir.
+func readPEM(dir string, path string) ([]byte, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("%s is referenced but no upstream TLS directory is configured", path)
+	}
+	root := filepath.Clean(dir)
+	file := filepath.Join(root, path)
+	if file != root && !strings.HasPrefix(file, root+string(filepath.Separator)) {
+		return nil, fmt.Errorf("%s is outside of the upstream TLS directory %s", path, dir)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", file, err)
+	}
+	if block, _ := pem.Decode(data); block == nil {
+		return nil, fmt.Errorf("%s does not contain PEM data", file)
+	}
+	return data, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/f5devcentral/bigip-tgw/as3"
+	"github.com/f5devcentral/bigip-tgw/config"
+	"github.com/f5devcentral/bigip-tgw/history"
+	slog "github.com/go-eden/slf4go"
+)
+
+const historyUsage = `usage: bigip-tgw history [-tenant <tenant>] list
+       bigip-tgw history [-tenant <tenant>] show <generation>
+       bigip-tgw history [-tenant <tenant>] diff <generation> [<generation>]
+       bigip-tgw history [-tenant <tenant>] apply <generation>`
+
+// runHistory browses the declarations saved to the history and applies them
+// again, it returns the exit code
+func runHistory(c *config.Config, args []string) int {
+	var log = slog.NewLogger("history")
+
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, historyUsage) }
+	tenant := flags.String("tenant", "", "tenant of the declarations, defaults to the tenant of the only gateway")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	args = flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		return 2
+	}
+	if *tenant == "" {
+		if len(c.Gateways) != 1 {
+			log.Errorf("several gateways are configured, select a tenant with -tenant")
+			return 2
+		}
+		*tenant = c.Gateways[0].Tenant
+	}
+
+	store, err := history.New(c.History)
+	if err != nil {
+		log.Errorf("unable to open the declaration history, error: %+v", err)
+		return 1
+	}
+
+	switch cmd, gens := args[0], args[1:]; {
+	case cmd == "list" && len(gens) == 0:
+		err = historyList(store, *tenant)
+	case cmd == "show" && len(gens) == 1:
+		err = historyShow(store, *tenant, gens[0])
+	case cmd == "diff" && (len(gens) == 1 || len(gens) == 2):
+		err = historyDiff(store, *tenant, gens)
+	case cmd == "apply" && len(gens) == 1:
+		err = historyApply(c, store, *tenant, gens[0])
+	default:
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		log.Errorf("%v", err)
+		return 1
+	}
+	return 0
+}
+
+func historyList(store *history.Store, tenant string) error {
+	entries, err := store.List(tenant)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "GENERATION\tTIME\tCODE\tREASON\tPOSTS\tHASH\tCONSUL INDEXES")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%d\t%s\t%s\n", e.Generation, e.Time.Local().Format(time.RFC3339),
+			e.Code, formatReason(e.Reason), postCount(e.Posts), e.Hash, formatIndexes(e.ConsulIndexes, ","))
+	}
+	return w.Flush()
+}
+
+func historyShow(store *history.Store, tenant string, gen string) error {
+	e, err := loadGeneration(store, tenant, gen)
+	if err != nil {
+		return err
+	}
+	declaration, err := history.Redacted(e)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, declaration, "", "  "); err != nil {
+		return err
+	}
+	fmt.Printf("Generation: %d\nTenant:     %s\nTime:       %s\nCode:       %d\nReason:     %s\nHash:       %s\n",
+		e.Generation, e.Tenant, e.Time.Local().Format(time.RFC3339), e.Code, formatReason(e.Reason), e.Hash)
+	if len(e.Posts) > 0 {
+		fmt.Println("Posts:")
+		for _, p := range e.Posts {
+			fmt.Printf("  %s - %s code %d %s, %d times\n", p.First.Local().Format(time.RFC3339),
+				p.Last.Local().Format(time.RFC3339), p.Code, formatReason(p.Reason), p.Count)
+		}
+	}
+	if len(e.ConsulIndexes) > 0 {
+		fmt.Printf("Consul indexes:\n  %s\n", formatIndexes(e.ConsulIndexes, "\n  "))
+	}
+	fmt.Println(out.String())
+	return nil
+}
+
+// historyDiff compares two generations, a single generation is compared
+// with the one before it
+func historyDiff(store *history.Store, tenant string, gens []string) error {
+	to, err := loadGeneration(store, tenant, gens[len(gens)-1])
+	if err != nil {
+		return err
+	}
+	var from history.Entry
+	if len(gens) == 2 {
+		from, err = loadGeneration(store, tenant, gens[0])
+	} else {
+		from, err = previousGeneration(store, tenant, to.Generation)
+	}
+	if err != nil {
+		return err
+	}
+
+	fromDeclaration, err := history.Redacted(from)
+	if err != nil {
+		return err
+	}
+	toDeclaration, err := history.Redacted(to)
+	if err != nil {
+		return err
+	}
+	lines, err := as3.DiffTenant(string(fromDeclaration), string(toDeclaration), tenant)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Tenant %s, generation %d -> %d, %d differences\n", tenant, from.Generation, to.Generation, len(lines))
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// historyApply posts a generation again. A running bigip-tgw replaces it
+// with the declaration of the Consul state at its next change, and at its
+// next drift check with DriftCorrect, so applying is refused in that case.
+func historyApply(c *config.Config, store *history.Store, tenant string, gen string) error {
+	e, err := loadGeneration(store, tenant, gen)
+	if err != nil {
+		return err
+	}
+	if c.Bigip.DriftCorrect && c.Bigip.DriftCheckInterval > 0 {
+		return fmt.Errorf("not applying generation %d, DriftCorrect is enabled and a running bigip-tgw would revert tenant %s "+
+			"within %d seconds. Stop it or disable DriftCorrect, e.g. with BIGIP_DRIFTCORRECT=false, to apply a generation",
+			e.Generation, tenant, c.Bigip.DriftCheckInterval)
+	}
+	declaration, err := store.Reveal(e)
+	if err != nil {
+		return err
+	}
+
+	agent := as3.CreateAgent()
+	if err := agent.Init(c.Bigip); err != nil {
+		return fmt.Errorf("unable to init agent, error: %+v", err)
+	}
+	if err := agent.PostManager.CheckTenantOwnership(tenant); err != nil {
+		return fmt.Errorf("not applying generation %d, error: %+v", e.Generation, err)
+	}
+	if err := agent.ApplyDeclaration(declaration, tenant); err != nil {
+		return err
+	}
+	fmt.Printf("Applied generation %d to tenant %s\n", e.Generation, tenant)
+	return nil
+}
+
+func loadGeneration(store *history.Store, tenant string, gen string) (history.Entry, error) {
+	n, err := strconv.Atoi(gen)
+	if err != nil || n <= 0 {
+		return history.Entry{}, fmt.Errorf("invalid generation %q", gen)
+	}
+	return store.Load(tenant, n)
+}
+
+func previousGeneration(store *history.Store, tenant string, generation int) (history.Entry, error) {
+	entries, err := store.List(tenant)
+	if err != nil {
+		return history.Entry{}, err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Generation < generation {
+			return entries[i], nil
+		}
+	}
+	return history.Entry{}, fmt.Errorf("no generation before %d in the history of tenant %s", generation, tenant)
+}
+
+func formatReason(reason string) string {
+	if reason == "" {
+		return "-"
+	}
+	return reason
+}
+
+func postCount(posts []history.Post) int {
+	n := 0
+	for _, p := range posts {
+		n += p.Count
+	}
+	return n
+}
+
+func formatIndexes(indexes map[string]uint64, sep string) string {
+	keys := make([]string, 0, len(indexes))
+	for k := range indexes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", k, indexes[k]))
+	}
+	return strings.Join(pairs, sep)
+}
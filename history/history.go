@@ -0,0 +1,260 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DefaultSize is the number of generations kept per tenant
+const DefaultSize = 50
+
+// maxPosts bounds the posts recorded per generation, the oldest are dropped
+const maxPosts = 20
+
+// entryFile matches the files of the entries, named after their generation
+var entryFile = regexp.MustCompile(`^(\d+)\.json$`)
+
+// Config of the declaration history
+type Config struct {
+	// Dir is the directory the declarations are saved to, empty disables the history
+	Dir string
+	// Size is the number of generations kept per tenant
+	Size int
+	// KeyFile holds the AES-256 key that encrypts the private keys of the
+	// declarations. Without it they are redacted and entries cannot be applied.
+	KeyFile string
+}
+
+// Entry is a declaration posted for a tenant and its outcome
+type Entry struct {
+	Generation int       `json:"generation"`
+	Tenant     string    `json:"tenant"`
+	Time       time.Time `json:"time"`
+	// Hash is the content hash the declaration id is made of
+	Hash string `json:"hash"`
+	// Code is the AS3 result code of the tenant, or the HTTP status code,
+	// 0 when the BIG-IP did not respond
+	Code int `json:"code"`
+	// Reason is rollback or drift-correction when the generation was
+	// restored, empty for its own posts and their retries
+	Reason string `json:"reason,omitempty"`
+	// Posts are the posts of the declaration, oldest first. Time, Code and
+	// Reason are the ones of the last post.
+	Posts []Post `json:"posts,omitempty"`
+	// ConsulIndexes identify the Consul state the declaration was made from
+	ConsulIndexes map[string]uint64 `json:"consulIndexes,omitempty"`
+	// Declaration has its secrets encrypted or redacted
+	Declaration json.RawMessage `json:"declaration"`
+}
+
+// Post records consecutive posts of the declaration of an entry with the
+// same code and reason, such as the retries of a rejected generation
+type Post struct {
+	First  time.Time `json:"first"`
+	Last   time.Time `json:"last"`
+	Code   int       `json:"code"`
+	Reason string    `json:"reason,omitempty"`
+	Count  int       `json:"count"`
+}
+
+// Store keeps the latest generations of every tenant in a directory
+type Store struct {
+	dir  string
+	size int
+	key  []byte
+}
+
+// New opens the history directory, creating it when needed
+func New(cfg Config) (*Store, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("no history directory is configured")
+	}
+	s := &Store{dir: cfg.Dir, size: cfg.Size}
+	if s.size <= 0 {
+		s.size = DefaultSize
+	}
+	if cfg.KeyFile != "" {
+		key, err := readKey(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		s.key = key
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create history directory %s: %v", s.dir, err)
+	}
+	return s, nil
+}
+
+func (s *Store) tenantDir(tenant string) string {
+	return filepath.Join(s.dir, tenant)
+}
+
+func (s *Store) entryPath(tenant string, generation int) string {
+	return filepath.Join(s.tenantDir(tenant), fmt.Sprintf("%010d.json", generation))
+}
+
+// Save writes an entry, adding the post to the ones of a previous entry of
+// the same generation, and drops the oldest generations beyond the size of
+// the history
+func (s *Store) Save(e Entry) error {
+	var posts []Post
+	if _, err := os.Stat(s.entryPath(e.Tenant, e.Generation)); err == nil {
+		previous, err := s.Load(e.Tenant, e.Generation)
+		if err != nil {
+			return err
+		}
+		posts = previous.Posts
+	}
+	e.Posts = addPost(posts, e)
+
+	declaration, err := protectSecrets(e.Declaration, s.key)
+	if err != nil {
+		return err
+	}
+	e.Declaration = declaration
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := s.tenantDir(e.Tenant)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".entry-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.entryPath(e.Tenant, e.Generation)); err != nil {
+		return err
+	}
+	return s.rotate(e.Tenant)
+}
+
+// addPost records the post of e, repeated posts with the same code and
+// reason are counted instead of added
+func addPost(posts []Post, e Entry) []Post {
+	if n := len(posts); n > 0 && posts[n-1].Code == e.Code && posts[n-1].Reason == e.Reason {
+		posts[n-1].Last = e.Time
+		posts[n-1].Count++
+		return posts
+	}
+	posts = append(posts, Post{First: e.Time, Last: e.Time, Code: e.Code, Reason: e.Reason, Count: 1})
+	if len(posts) > maxPosts {
+		posts = posts[len(posts)-maxPosts:]
+	}
+	return posts
+}
+
+func (s *Store) rotate(tenant string) error {
+	generations, err := s.generations(tenant)
+	if err != nil {
+		return err
+	}
+	for len(generations) > s.size {
+		if err := os.Remove(s.entryPath(tenant, generations[0])); err != nil {
+			return err
+		}
+		generations = generations[1:]
+	}
+	return nil
+}
+
+// generations lists the generations saved for a tenant, oldest first
+func (s *Store) generations(tenant string) ([]int, error) {
+	files, err := ioutil.ReadDir(s.tenantDir(tenant))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var generations []int
+	for _, f := range files {
+		m := entryFile.FindStringSubmatch(f.Name())
+		if f.IsDir() || m == nil {
+			continue
+		}
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+		generations = append(generations, n)
+	}
+	sort.Ints(generations)
+	return generations, nil
+}
+
+// Latest is the newest generation saved for a tenant, 0 without history
+func (s *Store) Latest(tenant string) (int, error) {
+	generations, err := s.generations(tenant)
+	if err != nil || len(generations) == 0 {
+		return 0, err
+	}
+	return generations[len(generations)-1], nil
+}
+
+// List returns the entries of a tenant, oldest first
+func (s *Store) List(tenant string) ([]Entry, error) {
+	generations, err := s.generations(tenant)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(generations))
+	for _, n := range generations {
+		e, err := s.Load(tenant, n)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Load reads an entry, its declaration still has its secrets protected
+func (s *Store) Load(tenant string, generation int) (Entry, error) {
+	var e Entry
+	data, err := ioutil.ReadFile(s.entryPath(tenant, generation))
+	if os.IsNotExist(err) {
+		return e, fmt.Errorf("generation %d of tenant %s is not in the history", generation, tenant)
+	}
+	if err != nil {
+		return e, err
+	}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return e, fmt.Errorf("unable to parse generation %d of tenant %s: %v", generation, tenant, err)
+	}
+	return e, nil
+}
+
+// Reveal returns the declaration of an entry with its secrets decrypted,
+// ready to be posted again
+func (s *Store) Reveal(e Entry) (string, error) {
+	declaration, err := revealSecrets(e.Declaration, s.key)
+	if err != nil {
+		return "", fmt.Errorf("generation %d of tenant %s cannot be applied: %v", e.Generation, e.Tenant, err)
+	}
+	return string(declaration), nil
+}
+
+// Redacted returns the declaration of an entry with all secrets hidden, to
+// be displayed or compared
+func Redacted(e Entry) (json.RawMessage, error) {
+	return protectSecrets(e.Declaration, nil)
+}
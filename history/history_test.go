@@ -0,0 +1,119 @@
+package history
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T, size int) (*Store, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(Config{Dir: dir, Size: size})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+func testEntry(generation int, code int, reason string, at time.Time) Entry {
+	return Entry{
+		Generation:  generation,
+		Tenant:      "T",
+		Time:        at,
+		Code:        code,
+		Reason:      reason,
+		Declaration: json.RawMessage(testDeclaration),
+	}
+}
+
+func TestRotate(t *testing.T) {
+	s, cleanup := newTestStore(t, 3)
+	defer cleanup()
+
+	now := time.Now().UTC()
+	for gen := 1; gen <= 5; gen++ {
+		if err := s.Save(testEntry(gen, 200, "", now)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := s.generations("T")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("generations = %v, want %v", got, want)
+	}
+	if latest, _ := s.Latest("T"); latest != 5 {
+		t.Errorf("Latest = %d, want 5", latest)
+	}
+
+	// posting an older generation again does not bring back rotated ones
+	if err := s.Save(testEntry(3, 200, "rollback", now)); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = s.generations("T")
+	if want := []int{3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("generations = %v, want %v", got, want)
+	}
+	if _, err := s.Load("T", 1); err == nil {
+		t.Errorf("rotated generation 1 is still loaded")
+	}
+}
+
+func TestSavePosts(t *testing.T) {
+	s, cleanup := newTestStore(t, 3)
+	defer cleanup()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * time.Second) }
+
+	// a generation rejected 100 times, then applied after a rollback
+	for i := 0; i < 100; i++ {
+		if err := s.Save(testEntry(2, 422, "", at(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Save(testEntry(2, 200, "", at(100))); err != nil {
+		t.Fatal(err)
+	}
+	e, err := s.Load("T", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Post{
+		{First: at(0), Last: at(99), Code: 422, Count: 100},
+		{First: at(100), Last: at(100), Code: 200, Count: 1},
+	}
+	if !reflect.DeepEqual(e.Posts, want) {
+		t.Errorf("Posts = %+v, want %+v", e.Posts, want)
+	}
+	if e.Code != 200 || !e.Time.Equal(at(100)) {
+		t.Errorf("entry has code %d at %v, want the last post", e.Code, e.Time)
+	}
+
+	// alternating results are bounded
+	for i := 0; i < 3*maxPosts; i++ {
+		reason := ""
+		if i%2 == 1 {
+			reason = "drift-correction"
+		}
+		if err := s.Save(testEntry(2, 200, reason, at(200+i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e, _ = s.Load("T", 2)
+	if len(e.Posts) != maxPosts {
+		t.Errorf("%d posts are kept, want %d", len(e.Posts), maxPosts)
+	}
+	if last := e.Posts[len(e.Posts)-1]; !last.Last.Equal(at(200 + 3*maxPosts - 1)) {
+		t.Errorf("last post at %v, want the newest", last.Last)
+	}
+}
@@ -0,0 +1,151 @@
+package history
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	redacted        = "REDACTED"
+	encryptedPrefix = "enc:"
+)
+
+// secretKeys are the AS3 properties that hold private key material
+var secretKeys = map[string]bool{
+	"privateKey": true,
+	"passphrase": true,
+}
+
+// readKey reads a 32 byte key, raw or hex encoded
+func readKey(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read history key: %v", err)
+	}
+	if key, err := hex.DecodeString(strings.TrimSpace(string(data))); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	return nil, fmt.Errorf("history key %s has to hold 32 bytes, raw or hex encoded", path)
+}
+
+// protectSecrets encrypts the secrets of a declaration with key, or redacts
+// them without key. Secrets that are already protected are left as they are.
+func protectSecrets(declaration json.RawMessage, key []byte) (json.RawMessage, error) {
+	return mapSecrets(declaration, func(secret string) (string, error) {
+		if secret == redacted || strings.HasPrefix(secret, encryptedPrefix) {
+			if key == nil {
+				return redacted, nil
+			}
+			return secret, nil
+		}
+		if key == nil {
+			return redacted, nil
+		}
+		return encrypt(key, secret)
+	})
+}
+
+// revealSecrets decrypts the secrets of a declaration
+func revealSecrets(declaration json.RawMessage, key []byte) (json.RawMessage, error) {
+	return mapSecrets(declaration, func(secret string) (string, error) {
+		switch {
+		case secret == redacted:
+			return "", fmt.Errorf("its private keys were redacted, configure history.keyfile to keep them")
+		case !strings.HasPrefix(secret, encryptedPrefix):
+			return secret, nil
+		case key == nil:
+			return "", fmt.Errorf("its private keys are encrypted and history.keyfile is not configured")
+		}
+		return decrypt(key, secret)
+	})
+}
+
+// mapSecrets rewrites the string values of the secret properties
+func mapSecrets(declaration json.RawMessage, f func(string) (string, error)) (json.RawMessage, error) {
+	decoder := json.NewDecoder(bytes.NewReader(declaration))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var walk func(v interface{}) error
+	walk = func(v interface{}) error {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			for k, child := range t {
+				if s, ok := child.(string); ok && secretKeys[k] {
+					mapped, err := f(s)
+					if err != nil {
+						return err
+					}
+					t[k] = mapped
+					continue
+				}
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		case []interface{}:
+			for _, child := range t {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+func encrypt(key []byte, secret string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decrypt(key []byte, secret string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, encryptedPrefix))
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("invalid encrypted private key")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt the private keys, history.keyfile does not match")
+	}
+	return string(plain), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
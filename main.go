@@ -1,13 +1,17 @@
 package main
 
 import (
+	"expvar"
+	"net/http"
 	"os"
-	"sync"
+	"os/signal"
+	"syscall"
 
 	"github.com/f5devcentral/bigip-tgw/as3"
 	"github.com/f5devcentral/bigip-tgw/config"
 	"github.com/f5devcentral/bigip-tgw/consul"
 	"github.com/f5devcentral/bigip-tgw/gateway"
+	"github.com/f5devcentral/bigip-tgw/history"
 	slog "github.com/go-eden/slf4go"
 )
 
@@ -22,54 +26,133 @@ func main() {
 		os.Exit(0)
 	}
 
-	//Init as3manager
-	agent := as3.CreateAgent()
-	err = agent.Init(c.Bigip)
-	if err != nil {
-		log.Errorf("unable to init agent, error: %+v", err)
-		os.Exit(0)
+	var tenantList []string
+	for _, gw := range c.Gateways {
+		tenantList = append(tenantList, gw.Tenant)
 	}
 
 	if len(os.Args) > 1 && os.Args[1] == "remove" {
-		err = agent.PostManager.DeletePartition([]string{"TGW_Tenant"})
+		agent := as3.CreateAgent()
+		err = agent.Init(c.Bigip)
 		if err != nil {
-			log.Errorf("unable to remove partition, error: %+v", err)
+			log.Errorf("unable to init agent, error: %+v", err)
 			os.Exit(0)
 		}
-		log.Info("removed AS3 partition TGW_Tenant")
+		for _, tenant := range tenantList {
+			err = agent.PostManager.CheckTenantOwnership(tenant)
+			if err != nil {
+				log.Errorf("not removing AS3 partitions, error: %+v", err)
+				os.Exit(0)
+			}
+		}
+		err = agent.PostManager.DeletePartition(tenantList)
+		if err != nil {
+			log.Errorf("unable to remove partitions, error: %+v", err)
+			os.Exit(0)
+		}
+		log.Infof("removed AS3 partitions %v", tenantList)
 		os.Exit(0)
 	}
 
-	//Init watcher
-	watcher := consul.New()
-	err = watcher.Init(c.Consul, c.Gateway.Name, c.Gateway.Namespace)
-	if err != nil {
-		log.Errorf("unable to create and configure Consul watcher, error: %+v", err)
-		os.Exit(0)
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		os.Exit(runHistory(c, os.Args[2:]))
+	}
+
+	if c.Metrics.Address != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/debug/vars", expvar.Handler())
+			log.Infof("serving metrics on %s/debug/vars", c.Metrics.Address)
+			err := http.ListenAndServe(c.Metrics.Address, mux)
+			log.Errorf("unable to serve metrics, error: %+v", err)
+		}()
 	}
 
-	//Init writer
-	writer := gateway.New(c.Bigip, watcher.C, agent.ReqChan)
-	//err = writer.Init(c.Bigip)
-	//if err != nil {
-	//	log.Errorf("unable to create and configure AS3 writer, error: %+v", err)
-	//	os.Exit(0)
-	//}
-	defer writer.DeInit()
+	var store *history.Store
+	if c.History.Dir != "" {
+		store, err = history.New(c.History)
+		if err != nil {
+			log.Errorf("unable to open the declaration history, error: %+v", err)
+			os.Exit(0)
+		}
+	}
 
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	go func() {
-		err := watcher.Run()
+	var watchers []*consul.Watcher
+	var writers []*gateway.Bigip
+	var agents []*as3.AS3Manager
+	for _, gw := range c.Gateways {
+		//Init as3manager, one per gateway so that each tenant is posted on its own
+		agent := as3.CreateAgent()
+		err = agent.Init(c.Bigip)
 		if err != nil {
-			log.Panicf("error running consul watcher: %+v", err)
+			log.Errorf("unable to init agent for gateway %s, error: %+v", gw.Name, err)
+			os.Exit(0)
 		}
-	}()
-	go func() {
-		err := writer.Run()
+		err = agent.PostManager.CheckTenantOwnership(gw.Tenant)
 		if err != nil {
-			log.Panicf("error running F5 writer: %+v", err)
+			log.Errorf("refusing to run gateway %s, error: %+v", gw.Name, err)
+			os.Exit(0)
+		}
+		if store != nil {
+			err = agent.EnableHistory(store, gw.Tenant)
+			if err != nil {
+				log.Errorf("unable to enable the history of gateway %s, error: %+v", gw.Name, err)
+				os.Exit(0)
+			}
+		}
+
+		//Init watcher
+		watcher := consul.New()
+		err = watcher.Init(c.Consul, gw.Name, gw.Namespace)
+		if err != nil {
+			log.Errorf("unable to create and configure Consul watcher for gateway %s, error: %+v", gw.Name, err)
+			os.Exit(0)
 		}
-	}()
-	wg.Wait()
+		watcher.SetHealthPolicy(gw.HealthPolicy, gw.ServiceHealthPolicies)
+		watchers = append(watchers, watcher)
+
+		//Init writer
+		writer := gateway.New(c.Bigip, gateway.Options{
+			Tenant:             gw.Tenant,
+			Application:        gw.Application,
+			VirtualServer:      gw.VirtualServer,
+			TCPMonitorFallback: gw.TCPMonitorFallback,
+			UpstreamTLSDir:     gw.UpstreamTLSDir,
+			SchemaVersion:      agent.SchemaVersion(),
+			Schema:             agent.Schema,
+		}, watcher.C, agent.ReqChan)
+		writers = append(writers, writer)
+		agents = append(agents, agent.AS3Manager)
+
+		go func(name string) {
+			err := watcher.Run()
+			if err != nil {
+				log.Panicf("error running consul watcher for gateway %s: %+v", name, err)
+			}
+		}(gw.Name)
+		go func(name string) {
+			err := writer.Run()
+			if err != nil {
+				log.Panicf("error running F5 writer for gateway %s: %+v", name, err)
+			}
+		}(gw.Name)
+	}
+
+	// Cancel the blocking queries on shutdown so they are not left on the Consul servers
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigs
+	log.Infof("received %v, stopping watchers", sig)
+	for _, watcher := range watchers {
+		watcher.Stop()
+	}
+	// The writers only stop once their watcher no longer sends, and close the
+	// agents' ReqChan after their last declaration
+	for _, writer := range writers {
+		writer.DeInit()
+	}
+	for _, agent := range agents {
+		agent.Wait()
+	}
+	log.Infof("stopped")
 }